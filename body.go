@@ -0,0 +1,85 @@
+// Response bodies are read through a limiting reader, so that a huge
+// file or an endless streaming endpoint can't hold up a worker until
+// the client's timeout.  Once -max_body_bytes have been read, the
+// reader fails, the extractor stops with what it has, and the page is
+// reported as truncated.  With -head_check, a HEAD request is sent
+// first, and pages whose Content-Length is over the limit aren't
+// fetched at all.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// The error category of pages that are larger than -max_body_bytes.
+var errBodyTooLarge = errors.New("response body too large")
+
+// A limitedBody reads at most limit bytes of a response body, after
+// which it fails with errBodyTooLarge if there is more to read.  A
+// limit of 0 means there is none.
+type limitedBody struct {
+	r         io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func newLimitedBody(r io.Reader, limit int64) *limitedBody {
+	if limit <= 0 {
+		limit = -1
+	}
+	return &limitedBody{r: r, remaining: limit}
+}
+
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	if lb.remaining < 0 {
+		return lb.r.Read(p)
+	}
+	if lb.exceeded {
+		return 0, errBodyTooLarge
+	}
+	if lb.remaining == 0 {
+		// The body is only too large if there is more of it.
+		var one [1]byte
+		n, err := io.ReadFull(lb.r, one[:])
+		if n == 0 {
+			return 0, err
+		}
+		lb.exceeded = true
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > lb.remaining {
+		p = p[:lb.remaining]
+	}
+	n, err := lb.r.Read(p)
+	lb.remaining -= int64(n)
+	return n, err
+}
+
+// Sends a HEAD request for the page, and returns an error wrapping
+// errBodyTooLarge if its Content-Length is over the limit.  Any other
+// failure is left for the GET request to find.
+func (wf *WordFinder) checkLength(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("User-Agent", *userAgent)
+	if err := wf.waitTurn(ctx, req.URL); err != nil {
+		return nil
+	}
+	resp, err := wf.client.Do(req)
+	if err != nil {
+		return nil
+	}
+	resp.Body.Close()
+	wf.checkOverload(resp)
+	if resp.StatusCode < 400 && resp.ContentLength > *maxBodyBytes {
+		return fmt.Errorf("%w: Content-Length %d, not fetched",
+			errBodyTooLarge, resp.ContentLength)
+	}
+	return nil
+}
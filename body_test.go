@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Test that the limiting reader passes bodies up to the limit, and
+// fails only once there is more.
+func TestLimitedBody(t *testing.T) {
+	tests := []struct {
+		body     string
+		limit    int64
+		expected string
+		exceeded bool
+	}{
+		{"abcdef", 0, "abcdef", false},
+		{"abcdef", 6, "abcdef", false},
+		{"abcdef", 10, "abcdef", false},
+		{"abcdef", 4, "abcd", true},
+	}
+	for _, tc := range tests {
+		lb := newLimitedBody(strings.NewReader(tc.body), tc.limit)
+		data, err := io.ReadAll(lb)
+		if string(data) != tc.expected || lb.exceeded != tc.exceeded {
+			t.Errorf("%q/%d: expected %q, got %q\n", tc.body, tc.limit,
+				tc.expected, data)
+		}
+		if tc.exceeded != errors.Is(err, errBodyTooLarge) {
+			t.Errorf("%q/%d: unexpected error %v\n", tc.body, tc.limit, err)
+		}
+	}
+}
+
+// Test that a large page and an endless stream are cut off at the
+// limit, keeping the words read before it, and that the HEAD check
+// skips pages whose declared length is too large.
+func TestMaxBodyBytes(t *testing.T) {
+	defer func(n int64) { *maxBodyBytes = n }(*maxBodyBytes)
+	defer func() { *headCheck = false }()
+	*maxBodyBytes = 1000
+	*minLen = 5
+	*maxLen = 0
+
+	filler := strings.Repeat("x ", 1000)
+	var mu sync.Mutex
+	gets := make(map[string]int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			gets[r.URL.Path]++
+			mu.Unlock()
+		}
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<a href="/large">x</a> <a href="/stream">x</a>
+				<a href="/small">x</a> Homepage`))
+		case "/small":
+			w.Write([]byte("Smallpage"))
+		case "/large":
+			w.Write([]byte("Largestart " + filler + " Largeend"))
+		case "/stream":
+			if r.Method == http.MethodHead {
+				return
+			}
+			w.Write([]byte("Streamstart "))
+			for r.Context().Err() == nil {
+				if _, err := w.Write([]byte(filler)); err != nil {
+					return
+				}
+			}
+		}
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("URL parse failed: %v\n", err)
+	}
+	for _, head := range []bool{false, true} {
+		*headCheck = head
+		gets = make(map[string]int)
+		finder, err := newWordFinder([]*url.URL{u}, newFormatter())
+		if err != nil {
+			t.Fatalf("creating finder failed: %v\n", err)
+		}
+		finder.run(context.Background())
+
+		var large []string
+		for _, r := range finder.getErrors() {
+			if !errors.Is(r.err, errBodyTooLarge) {
+				t.Errorf("unexpected error for '%s': %v\n", r.url, r.err)
+			}
+			large = append(large, strings.TrimPrefix(r.url, ts.URL))
+		}
+		sort.Strings(large)
+		if strings.Join(large, " ") != "/large /stream" {
+			t.Errorf("head %v: expected large and stream, got %v\n", head,
+				large)
+		}
+
+		expected := map[string]int{"Homepage": 1, "Smallpage": 1,
+			"Streamstart": 1, "Largestart": 1, "Largeend": 0}
+		if head {
+			expected["Largestart"] = 0
+		}
+		for w, n := range expected {
+			if finder.words.counts[w] != n {
+				t.Errorf("head %v: expected %d counts of '%s', got %d\n",
+					head, n, w, finder.words.counts[w])
+			}
+		}
+		if head && gets["/large"] != 0 {
+			t.Errorf("fetched a page the HEAD check found too large\n")
+		}
+	}
+}
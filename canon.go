@@ -0,0 +1,190 @@
+// The canonicalizer reduces the many spellings of a URL to a single
+// form, so that the visited map doesn't let us crawl (and count the
+// words of) the same page several times over.
+package main
+
+import (
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Query parameters used only for tracking, which are stripped by default.
+// A trailing '*' matches any parameter with that prefix.
+const defaultStripParams = "utm_*,gclid,fbclid,msclkid,dclid,mc_cid,mc_eid,_ga,_hsenc,_hsmi"
+
+// The canonicalizer holds the configurable parts of canonicalization.
+type canonicalizer struct {
+	strip     map[string]bool
+	prefixes  []string
+	foldSlash bool
+}
+
+// Creates a canonicalizer that strips the given (case-insensitive)
+// query parameters, and optionally removes trailing slashes.
+func newCanonicalizer(strip []string, foldSlash bool) *canonicalizer {
+	c := &canonicalizer{strip: make(map[string]bool), foldSlash: foldSlash}
+	for _, p := range strip {
+		p = strings.ToLower(strings.TrimSpace(p))
+		switch {
+		case p == "":
+		case strings.HasSuffix(p, "*"):
+			c.prefixes = append(c.prefixes, p[:len(p)-1])
+		default:
+			c.strip[p] = true
+		}
+	}
+	return c
+}
+
+// Returns the canonical form of an absolute URL: the scheme and host
+// are lowercased, default ports are dropped, percent-encoding is
+// normalized, dot segments are removed from the path, tracking
+// parameters are stripped and the rest sorted by name, and the
+// fragment is discarded.  Only http and https URLs are changed.
+func (c *canonicalizer) canonical(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	if (scheme != "http" && scheme != "https") || u.Opaque != "" {
+		return u.String()
+	}
+
+	var b strings.Builder
+	b.WriteString(scheme)
+	b.WriteString("://")
+	if u.User != nil {
+		b.WriteString(u.User.String())
+		b.WriteByte('@')
+	}
+	b.WriteString(canonicalHost(scheme, u))
+	b.WriteString(c.canonicalPath(u.EscapedPath()))
+	if q := c.canonicalQuery(u.RawQuery); q != "" {
+		b.WriteByte('?')
+		b.WriteString(q)
+	}
+	return b.String()
+}
+
+// Lowercases the host, removes any trailing dot and drops the port
+// if it is the default for the scheme.
+func canonicalHost(scheme string, u *url.URL) string {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	port := u.Port()
+	if port == "" || (scheme == "http" && port == "80") ||
+		(scheme == "https" && port == "443") {
+		return host
+	}
+	return host + ":" + port
+}
+
+// Normalizes the escaping of the path and removes "." and ".."
+// segments, along with empty ones.  A trailing slash is kept unless
+// we're folding them.
+func (c *canonicalizer) canonicalPath(p string) string {
+	p = normalizeEscapes(p)
+	if p == "" {
+		return "/"
+	}
+	trailing := strings.HasSuffix(p, "/") || strings.HasSuffix(p, "/.") ||
+		strings.HasSuffix(p, "/..")
+	p = path.Clean("/" + p)
+	if trailing && !c.foldSlash && p != "/" {
+		p += "/"
+	}
+	return p
+}
+
+// Removes tracking parameters and empty pairs from the query, and
+// sorts the rest by name.  Parameters with the same name keep their
+// relative order, as it may be significant.
+func (c *canonicalizer) canonicalQuery(q string) string {
+	if q == "" {
+		return ""
+	}
+	type param struct{ name, pair string }
+	var params []param
+	for _, pair := range strings.Split(q, "&") {
+		if pair == "" {
+			continue
+		}
+		pair = normalizeEscapes(pair)
+		name, _, _ := strings.Cut(pair, "=")
+		if dn, err := url.QueryUnescape(name); err == nil {
+			name = dn
+		}
+		if c.stripped(name) {
+			continue
+		}
+		params = append(params, param{name, pair})
+	}
+	sort.SliceStable(params, func(i, j int) bool {
+		return params[i].name < params[j].name
+	})
+	s := make([]string, len(params))
+	for i, p := range params {
+		s[i] = p.pair
+	}
+	return strings.Join(s, "&")
+}
+
+// Reports whether the query parameter should be removed.
+func (c *canonicalizer) stripped(name string) bool {
+	name = strings.ToLower(name)
+	if c.strip[name] {
+		return true
+	}
+	for _, p := range c.prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Decodes percent-escaped unreserved characters, which never need
+// escaping, and uppercases the hex digits of the escapes that remain.
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteString(strings.ToUpper(s[i+1 : i+3]))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') ||
+		('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
+
+// The unreserved characters of RFC 3986.
+func isUnreserved(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') ||
+		('0' <= c && c <= '9') || c == '-' || c == '.' || c == '_' || c == '~'
+}
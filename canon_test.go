@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Test the canonical forms of URLs with the default settings.
+func TestCanonicalURL(t *testing.T) {
+	c := newCanonicalizer(strings.Split(defaultStripParams, ","), false)
+	tests := []struct {
+		in, out string
+	}{
+		// Scheme and host case, default ports and trailing dots.
+		{"HTTP://Example.COM/a", "http://example.com/a"},
+		{"http://example.com:80/a", "http://example.com/a"},
+		{"https://example.com:443/a", "https://example.com/a"},
+		{"http://example.com:443/a", "http://example.com:443/a"},
+		{"https://example.com:8443/a", "https://example.com:8443/a"},
+		{"http://example.com./a", "http://example.com/a"},
+		{"http://[::1]:80/a", "http://[::1]/a"},
+		{"http://user:pw@Example.com/", "http://user:pw@example.com/"},
+
+		// Empty paths and dot segments.
+		{"http://example.com", "http://example.com/"},
+		{"http://example.com/a/../a", "http://example.com/a"},
+		{"http://example.com/a/./b", "http://example.com/a/b"},
+		{"http://example.com/a/b/..", "http://example.com/a/"},
+		{"http://example.com/a/b/.", "http://example.com/a/b/"},
+		{"http://example.com/../../a", "http://example.com/a"},
+		{"http://example.com/a//b", "http://example.com/a/b"},
+		{"http://example.com/a/", "http://example.com/a/"},
+
+		// Percent-encoding.
+		{"http://example.com/%7Euser", "http://example.com/~user"},
+		{"http://example.com/a%2fb", "http://example.com/a%2Fb"},
+		{"http://example.com/caf%c3%a9", "http://example.com/caf%C3%A9"},
+		{"http://example.com/%2E%2E/a", "http://example.com/a"},
+		{"http://example.com/a?q=%7e", "http://example.com/a?q=~"},
+
+		// Query parameters are sorted and tracking ones stripped.
+		{"http://example.com/a?b=2&a=1", "http://example.com/a?a=1&b=2"},
+		{"http://example.com/a?x=2&a=1&x=1", "http://example.com/a?a=1&x=2&x=1"},
+		{"http://example.com/a?utm_source=x", "http://example.com/a"},
+		{"http://example.com/a?UTM_Medium=x&id=3", "http://example.com/a?id=3"},
+		{"http://example.com/a?id=3&gclid=abc&fbclid=d", "http://example.com/a?id=3"},
+		{"http://example.com/a?&&id=3&", "http://example.com/a?id=3"},
+		{"http://example.com/a?", "http://example.com/a"},
+		{"http://example.com/a?utm=keep", "http://example.com/a?utm=keep"},
+
+		// Fragments are dropped.
+		{"http://example.com/a#section", "http://example.com/a"},
+
+		// Other schemes are left alone.
+		{"mailto:Someone@Example.com", "mailto:Someone@Example.com"},
+		{"ftp://Example.com/a/../b", "ftp://Example.com/a/../b"},
+	}
+	for _, tc := range tests {
+		u, err := url.Parse(tc.in)
+		if err != nil {
+			t.Fatalf("URL parse of %q failed: %v\n", tc.in, err)
+		}
+		if got := c.canonical(u); got != tc.out {
+			t.Errorf("canonical(%q): expected %q, got %q\n", tc.in, tc.out, got)
+		}
+	}
+}
+
+// Test that equivalent spellings collapse to one URL, and the
+// optional settings.
+func TestCanonicalEquivalence(t *testing.T) {
+	same := []string{
+		"http://example.com/a",
+		"HTTP://EXAMPLE.com:80/a",
+		"http://example.com/b/../a",
+		"http://example.com/./a?utm_source=x&utm_campaign=y",
+		"http://example.com/%61#top",
+	}
+	c := newCanonicalizer(strings.Split(defaultStripParams, ","), false)
+	for _, s := range same {
+		u, _ := url.Parse(s)
+		if got := c.canonical(u); got != "http://example.com/a" {
+			t.Errorf("canonical(%q): got %q\n", s, got)
+		}
+	}
+
+	// Trailing slash folding, which never removes the root.
+	c = newCanonicalizer(nil, true)
+	for in, out := range map[string]string{
+		"http://example.com/a/":   "http://example.com/a",
+		"http://example.com/a/b/": "http://example.com/a/b",
+		"http://example.com/":     "http://example.com/",
+		"http://example.com/a/..": "http://example.com/",
+	} {
+		u, _ := url.Parse(in)
+		if got := c.canonical(u); got != out {
+			t.Errorf("canonical(%q): expected %q, got %q\n", in, out, got)
+		}
+	}
+
+	// With nothing to strip, tracking parameters are kept.
+	u, _ := url.Parse("http://example.com/a?utm_source=x")
+	if got := c.canonical(u); got != "http://example.com/a?utm_source=x" {
+		t.Errorf("unexpected strip: %q\n", got)
+	}
+}
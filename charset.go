@@ -0,0 +1,89 @@
+// Pages in legacy encodings such as Latin-1, Shift_JIS or Windows-1251
+// are transcoded to UTF-8 before their words are extracted, otherwise
+// every non-ASCII character would come out as garbage.  The encoding is
+// found the way a browser finds it: from a byte order mark, then the
+// charset parameter of the Content-Type header, then a <meta charset>
+// or <meta http-equiv> tag near the start of the page.  Failing those,
+// the body is taken as UTF-8, unless the start of it has bytes that
+// can't be UTF-8, in which case it is taken as Windows-1252, the web's
+// most common legacy encoding.  XML documents declare their own
+// encoding, which the XML decoder transcodes from, and JSON is always
+// UTF-8.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// How much of the body is looked at to determine the encoding.  This
+// is how far browsers look for a <meta> tag.
+const charsetPeekSize = 1024
+
+// The encoding given in an XML declaration.
+var xmlEncoding = regexp.MustCompile(
+	`^\s*<\?xml[^>]*\sencoding\s*=\s*["']([^"']+)["']`)
+
+// Returns a reader of the body as UTF-8, given its Content-Type header,
+// along with the name of the charset it was found to be in.
+func utf8Reader(br *bufio.Reader, contentType string) (io.Reader, string) {
+	peek, _ := br.Peek(charsetPeekSize)
+	e, name, certain := charset.DetermineEncoding(peek, contentType)
+	if !certain && name == "windows-1252" && !hasHighBit(peek) &&
+		!bytes.Contains(bytes.ToLower(peek), []byte("charset")) {
+		// Nothing was declared, and all we have seen is ASCII, so
+		// there is no reason to think it isn't UTF-8.
+		return br, "utf-8"
+	}
+	if e == encoding.Nop || name == "utf-8" {
+		return br, name
+	}
+	return transform.NewReader(br, e.NewDecoder()), name
+}
+
+// Returns a decoder of an XML document, recording its charset.  A byte
+// order mark or a charset in the Content-Type header is used if there
+// is one, otherwise the decoder reads the document's XML declaration,
+// and without one the document is UTF-8.
+func (wf *WordFinder) xmlDecoder(sr searchRecord, body *bufio.Reader,
+	contentType string) *xml.Decoder {
+	var dec *xml.Decoder
+	peek, _ := body.Peek(charsetPeekSize)
+	if _, _, certain := charset.DetermineEncoding(peek,
+		contentType); certain {
+		dec = xml.NewDecoder(wf.decodeBody(sr, body, contentType))
+		dec.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) {
+			// The body has already been transcoded.
+			return r, nil
+		}
+	} else {
+		name := "utf-8"
+		if m := xmlEncoding.FindSubmatch(peek); m != nil {
+			if _, n := charset.Lookup(string(m[1])); n != "" {
+				name = n
+			}
+		}
+		wf.noteCharset(sr.url, name)
+		dec = xml.NewDecoder(body)
+		dec.CharsetReader = charset.NewReaderLabel
+	}
+	dec.Strict = false
+	return dec
+}
+
+// Reports whether any byte isn't ASCII.
+func hasHighBit(b []byte) bool {
+	for _, c := range b {
+		if c >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+)
+
+// Test that pages in legacy charsets are transcoded, whether the
+// charset is given in the header, a <meta> tag, a byte order mark or an
+// XML declaration, and that pages declaring none are taken as UTF-8,
+// even when their first KB is all ASCII.
+func TestCharsets(t *testing.T) {
+	encode := func(e encoding.Encoding, s string) string {
+		b, err := e.NewEncoder().String(s)
+		if err != nil {
+			t.Fatalf("encoding '%s' failed: %v\n", s, err)
+		}
+		return b
+	}
+	pad := strings.Repeat("padding ", 150)
+	pages := map[string]struct {
+		contentType string
+		body        string
+	}{
+		"/": {"text/html", `<a href="/cyrillic">c</a> <a href="/latin">l</a>
+			<a href="/japanese">j</a> <a href="/bom">b</a>
+			<a href="/html">h</a> <a href="/text">t</a> <a href="/json">j</a>
+			<a href="/xml">x</a> <a href="/legacy.xml">l</a>
+			<a href="/feed">f</a>`},
+		"/cyrillic": {"text/html; charset=windows-1251",
+			encode(charmap.Windows1251, "<p>привет</p>")},
+		"/latin": {"text/html", encode(charmap.ISO8859_1,
+			`<meta charset="iso-8859-1"><p>façade</p>`)},
+		"/japanese": {"text/plain; charset=Shift_JIS",
+			encode(japanese.ShiftJIS, "こんにちは")},
+		"/bom": {"text/plain",
+			"\xfe\xff\x00s\x00o\x00m\x00m\x00e\x00i\x00l"},
+		"/html": {"text/html", "<p>" + pad + "brûlée</p>"},
+		"/text": {"text/plain", pad + "naïve"},
+		"/json": {"application/json", `{"k": "` + pad + `crème"}`},
+		"/xml":  {"application/xml", "<doc>" + pad + "décor</doc>"},
+		"/legacy.xml": {"application/xml", encode(charmap.ISO8859_1,
+			`<?xml version="1.0" encoding="ISO-8859-1"?><doc>`+pad+
+				"garçon</doc>")},
+		"/feed": {"application/rss+xml", encode(charmap.Windows1252,
+			`<?xml version="1.0" encoding="windows-1252"?><rss><channel>`+
+				"<item><title>"+pad+"fiancé</title></item></channel></rss>")},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		p, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", p.contentType)
+		w.Write([]byte(p.body))
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("URL parse failed: %v\n", err)
+	}
+	*minLen = 5
+	*maxLen = 0
+	finder, err := newWordFinder([]*url.URL{u}, newFormatter())
+	if err != nil {
+		t.Fatalf("creating finder failed: %v\n", err)
+	}
+	finder.run(context.Background())
+	for _, w := range []string{"привет", "façade", "こんにちは", "sommeil",
+		"brûlée", "naïve", "crème", "décor", "garçon", "fiancé"} {
+		if finder.words.counts[w] != 1 {
+			t.Errorf("expected one count of '%s', got %d\n", w,
+				finder.words.counts[w])
+		}
+	}
+	st := finder.getStats()
+	if st.charsets["windows-1251"] != 1 || st.charsets["shift_jis"] != 1 ||
+		st.charsets["utf-16be"] != 1 || st.charsets["windows-1252"] != 3 ||
+		st.charsets["utf-8"] != 5 {
+		t.Errorf("unexpected charsets: %v\n", st.charsets)
+	}
+}
@@ -0,0 +1,165 @@
+// The word counts hold the histogram for a single page, or for the
+// whole crawl.  Besides the raw number of times each word was seen,
+// they can hold a weighted total, in which a word in a title or a
+// heading counts for more than one in the body text, and when words
+// are normalized, the surface forms that were counted under each key.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Default weights for the elements that stand out on a page.  The
+// weight of any other text is 1.
+var defaultWeights = map[string]float64{
+	"title":  5,
+	"h1":     4,
+	"h2":     3,
+	"h3":     2,
+	"h4":     1.5,
+	"h5":     1.5,
+	"h6":     1.5,
+	"strong": 1.5,
+	"b":      1.5,
+	"em":     1.2,
+	"i":      1.2,
+}
+
+// The wordCounts are the raw counts of each word, and if weighting
+// is on, their weighted totals.
+type wordCounts struct {
+	counts  map[string]int
+	weights map[string]float64        // nil unless weighting is on
+	forms   map[string]map[string]int // nil unless tracking surface forms
+	stopped int                       // stopwords dropped
+	phrases *wordCounts               // nil unless counting phrases
+}
+
+// Creates an empty set of counts with room for size words, and if
+// phrases are being counted, for as many phrases.
+func newWordCounts(size int, weighted bool) *wordCounts {
+	wc := newCounts(size, weighted)
+	if *ngram > 1 {
+		wc.phrases = newCounts(size, weighted)
+	}
+	return wc
+}
+
+func newCounts(size int, weighted bool) *wordCounts {
+	wc := &wordCounts{counts: make(map[string]int, size)}
+	if weighted {
+		wc.weights = make(map[string]float64, size)
+	}
+	if trackForms() {
+		wc.forms = make(map[string]map[string]int, size)
+	}
+	return wc
+}
+
+// Counts one occurrence of the word under its normalized key, with
+// the given weight.
+func (wc *wordCounts) add(key, word string, weight float64) {
+	wc.counts[key]++
+	if wc.weights != nil {
+		wc.weights[key] += weight
+	}
+	if wc.forms != nil {
+		wc.addForm(key, word, 1)
+	}
+}
+
+// Counts n occurrences of a surface form of the key.
+func (wc *wordCounts) addForm(key, word string, n int) {
+	f := wc.forms[key]
+	if f == nil {
+		f = make(map[string]int, 1)
+		wc.forms[key] = f
+	}
+	f[word] += n
+}
+
+// Adds another set of counts to this one.
+func (wc *wordCounts) merge(other *wordCounts) {
+	wc.stopped += other.stopped
+	for k, v := range other.counts {
+		wc.counts[k] += v
+	}
+	if wc.weights != nil {
+		for k, v := range other.weights {
+			wc.weights[k] += v
+		}
+	}
+	if wc.forms != nil {
+		for k, f := range other.forms {
+			for w, n := range f {
+				wc.addForm(k, w, n)
+			}
+		}
+	}
+	if wc.phrases != nil && other.phrases != nil {
+		wc.phrases.merge(other.phrases)
+	}
+}
+
+// Returns the most common surface form of the key, or the key itself
+// if surface forms aren't tracked.  Ties go to the first in sort
+// order, so the result doesn't depend on map iteration.
+func (wc *wordCounts) form(key string) string {
+	best, bestN := key, 0
+	for w, n := range wc.forms[key] {
+		if n > bestN || (n == bestN && w < best) {
+			best, bestN = w, n
+		}
+	}
+	return best
+}
+
+// Reports whether no words or phrases have been counted or dropped.
+func (wc *wordCounts) empty() bool {
+	return wc == nil ||
+		(len(wc.counts) == 0 && wc.stopped == 0 && wc.phrases.empty())
+}
+
+// Reads element weights from a file with lines of the form
+// "<element> <weight>", such as "h1 4".  Blank lines and lines starting
+// with '#' are skipped.  The weights given replace the defaults, and
+// the defaults are kept for any element not mentioned.
+func loadWeights(path string) (map[string]float64, error) {
+	weights := make(map[string]float64, len(defaultWeights))
+	for k, v := range defaultWeights {
+		weights[k] = v
+	}
+	if path == "" {
+		return weights, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected '<element> <weight>'",
+				path, n)
+		}
+		w, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("%s:%d: bad weight '%s'", path, n,
+				fields[1])
+		}
+		weights[strings.ToLower(fields[0])] = w
+	}
+	return weights, sc.Err()
+}
@@ -0,0 +1,114 @@
+// The element stack tracks which HTML elements enclose the current
+// position in the token stream, so that we can tell, for example,
+// whether some text is inside a <script> block.  Real-world markup is
+// often malformed, so the stack is forgiving: void elements are never
+// pushed, and an end tag with no matching start tag is ignored, while
+// one that does match also closes any elements left open inside it.
+package main
+
+import "strings"
+
+// Elements whose text is skipped by default, as it is code, styling or
+// markup rather than the page's prose.
+const defaultSkipElements = "script,style,noscript,template,svg,math,object,canvas,iframe"
+
+// Elements that never have content or an end tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+// The elementStack is the list of open elements, innermost last.
+type elementStack struct {
+	names   []string
+	skip    map[string]bool
+	open    map[string]int
+	skipped int
+}
+
+// Creates an empty stack that skips text inside the given elements.
+func newElementStack(skip map[string]bool) *elementStack {
+	return &elementStack{skip: skip, open: make(map[string]int)}
+}
+
+// Pushes a start tag on the stack, unless it is a void element.
+func (es *elementStack) push(name string) {
+	if voidElements[name] {
+		return
+	}
+	es.names = append(es.names, name)
+	es.open[name]++
+	if es.skip[name] {
+		es.skipped++
+	}
+}
+
+// Pushes a self-closing tag, such as "<script src=x />", on the stack.
+// The slash only closes elements in SVG and MathML content.  In HTML
+// it is ignored, and the tokenizer goes on to read what follows a
+// <script> or <style> as raw text, so the element is left open.
+func (es *elementStack) pushSelfClosing(name string) {
+	if name == "svg" || name == "math" || es.inside("svg") ||
+		es.inside("math") {
+		return
+	}
+	es.push(name)
+}
+
+// Pops the innermost open element with the given name, along with any
+// elements opened inside it that were never closed.
+func (es *elementStack) pop(name string) {
+	if es.open[name] == 0 {
+		return
+	}
+	for len(es.names) > 0 {
+		top := es.names[len(es.names)-1]
+		es.names = es.names[:len(es.names)-1]
+		es.open[top]--
+		if es.skip[top] {
+			es.skipped--
+		}
+		if top == name {
+			return
+		}
+	}
+}
+
+// Reports whether an element with the given name is open.
+func (es *elementStack) inside(name string) bool {
+	return es.open[name] > 0
+}
+
+// Reports whether the current text should be skipped.
+func (es *elementStack) skipping() bool {
+	return es.skipped > 0
+}
+
+// Returns the weight of text at the current position: that of the
+// most heavily weighted enclosing element that has a weight, or 1 if
+// there are none.  So weights below 1 can be used to play down
+// elements such as footers.
+func (es *elementStack) weight(weights map[string]float64) float64 {
+	w := -1.0
+	for _, name := range es.names {
+		if ew, ok := weights[name]; ok && ew > w {
+			w = ew
+		}
+	}
+	if w < 0 {
+		return 1
+	}
+	return w
+}
+
+// Parses a comma-separated list of element names into a set.
+func elementSet(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, e := range strings.Split(list, ",") {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+			set[e] = true
+		}
+	}
+	return set
+}
@@ -0,0 +1,192 @@
+// Extractors get the words, and any links, from the documents the
+// crawler fetches.  Each handles the media types registered for it, so
+// HTML is parsed as HTML, JSON is decoded and only its string values
+// counted, and so on.  Images, audio, video and opaque binary data are
+// skipped, and any type we know nothing about is reported in the stats
+// rather than having its bytes tokenized as text.  The -allow_types and
+// -deny_types flags adjust this: an allowed type with no extractor of
+// its own is read as plain text.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// An Extractor returns the words, links and directives of a document,
+// given its body and Content-Type header.  The error is any failure to
+// read the body, such as a timeout, which may go away if we try again.
+type Extractor func(ctx context.Context, sr searchRecord, body *bufio.Reader,
+	contentType string, wf *WordFinder) (*wordCounts, []string,
+	pageDirectives, error)
+
+// The extractors for each media type.  Types ending in "+xml" or
+// "+json" are handled as XML or JSON unless they are listed here.
+var extractors = map[string]Extractor{
+	"text/html":             extractHTML,
+	"application/xhtml+xml": extractHTML,
+	"text/plain":            extractText,
+	"text/xml":              extractXML,
+	"application/xml":       extractXML,
+	"application/json":      extractJSON,
+	"application/rss+xml":   extractFeed,
+	"application/atom+xml":  extractFeed,
+	"application/pdf":       extractPDF,
+}
+
+// The media types that are skipped unless they are allowed.
+const defaultSkipTypes = "image/*,audio/*,video/*,font/*," +
+	"application/octet-stream,application/binary"
+
+// Returns the extractor for the media type, or nil if the type is to
+// be skipped.  known is false for a type that isn't registered, or
+// skipped by default, or named by the flags.
+func (wf *WordFinder) extractorFor(mediaType string) (ext Extractor,
+	known bool) {
+	switch {
+	case typeMatches(wf.denyTypes, mediaType):
+		return nil, true
+	case extractors[mediaType] != nil:
+		return extractors[mediaType], true
+	case strings.HasSuffix(mediaType, "+xml"):
+		return extractXML, true
+	case strings.HasSuffix(mediaType, "+json"):
+		return extractJSON, true
+	case typeMatches(wf.allowTypes, mediaType):
+		return extractText, true
+	case typeMatches(strings.Split(defaultSkipTypes, ","), mediaType):
+		return nil, true
+	}
+	return nil, false
+}
+
+// Parses a comma-separated list of media types, such as
+// "application/pdf,image/*".
+func mediaTypeList(list string) []string {
+	var types []string
+	for _, t := range strings.Split(list, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// Reports whether the media type matches any in the list.  A "/*"
+// suffix matches any subtype.
+func typeMatches(types []string, mediaType string) bool {
+	for _, t := range types {
+		if t == mediaType || (strings.HasSuffix(t, "/*") &&
+			strings.HasPrefix(mediaType, t[:len(t)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a reader of the body as UTF-8, recording its charset.
+func (wf *WordFinder) decodeBody(sr searchRecord, body *bufio.Reader,
+	contentType string) io.Reader {
+	r, cs := utf8Reader(body, contentType)
+	wf.noteCharset(sr.url, cs)
+	return r
+}
+
+func extractHTML(ctx context.Context, sr searchRecord, body *bufio.Reader,
+	contentType string, wf *WordFinder) (*wordCounts, []string,
+	pageDirectives, error) {
+	return sr.processHTML(ctx, wf.decodeBody(sr, body, contentType), wf)
+}
+
+func extractText(ctx context.Context, sr searchRecord, body *bufio.Reader,
+	contentType string, wf *WordFinder) (*wordCounts, []string,
+	pageDirectives, error) {
+	r := bufio.NewReader(wf.decodeBody(sr, body, contentType))
+	wds, err := sr.processAsText(ctx, r, wf)
+	return wds, nil, pageDirectives{}, err
+}
+
+// Counts the words in the character data of an XML document.  Element
+// and attribute names are markup, not text, so aren't counted.  Feeds
+// served as plain XML are handed to the feed extractor.
+func extractXML(ctx context.Context, sr searchRecord, body *bufio.Reader,
+	contentType string, wf *WordFinder) (*wordCounts, []string,
+	pageDirectives, error) {
+	if isFeed(body) {
+		return extractFeed(ctx, sr, body, contentType, wf)
+	}
+	wds := newWordCounts(0, wf.weights != nil)
+	dec := wf.xmlDecoder(sr, body, contentType)
+	for ctx.Err() == nil {
+		tok, err := dec.Token()
+		if err != nil {
+			return wds, nil, pageDirectives{}, readError(err)
+		}
+		if cd, ok := tok.(xml.CharData); ok {
+			scanText(string(cd), wds, 1)
+		}
+	}
+	return wds, nil, pageDirectives{}, nil
+}
+
+// Counts the words in the string values of a JSON document.  Object
+// keys are field names, not text, so aren't counted.  JSON is UTF-8 by
+// definition (RFC 8259), whatever the header says.
+func extractJSON(ctx context.Context, sr searchRecord, body *bufio.Reader,
+	contentType string, wf *WordFinder) (*wordCounts, []string,
+	pageDirectives, error) {
+	wds := newWordCounts(0, wf.weights != nil)
+	wf.noteCharset(sr.url, "utf-8")
+	dec := json.NewDecoder(body)
+
+	// For each open container, whether it's an object, and so whether
+	// its strings alternate between keys and values.
+	var objects []bool
+	expectKey := false
+	for ctx.Err() == nil {
+		tok, err := dec.Token()
+		if err != nil {
+			return wds, nil, pageDirectives{}, readError(err)
+		}
+		switch v := tok.(type) {
+		case json.Delim:
+			switch v {
+			case '{', '[':
+				objects = append(objects, v == '{')
+				expectKey = v == '{'
+				continue
+			}
+			objects = objects[:len(objects)-1]
+			expectKey = true
+		case string:
+			if !expectKey {
+				scanText(v, wds, 1)
+			}
+			expectKey = !expectKey
+		default:
+			expectKey = !expectKey
+		}
+		if len(objects) == 0 || !objects[len(objects)-1] {
+			expectKey = false
+		}
+	}
+	return wds, nil, pageDirectives{}, nil
+}
+
+// Returns the error that stopped the reading of a document, or nil if
+// it was read to the end.  Syntax errors are in the document itself,
+// and wouldn't go away if we fetched it again, so are not returned.
+func readError(err error) error {
+	var xe *xml.SyntaxError
+	var je *json.SyntaxError
+	if err == io.EOF || errors.Is(err, context.Canceled) ||
+		errors.As(err, &xe) || errors.As(err, &je) {
+		return nil
+	}
+	return err
+}
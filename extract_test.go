@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Test the choice of extractor for each media type, and that the
+// allow and deny lists override the defaults.
+func TestExtractorFor(t *testing.T) {
+	wf := testFinder(t)
+	wf.allowTypes = mediaTypeList("text/*, image/svg+xml, video/mp4")
+	wf.denyTypes = mediaTypeList("application/json")
+
+	tests := []struct {
+		mediaType string
+		ext       Extractor
+		known     bool
+	}{
+		{"text/html", extractHTML, true},
+		{"application/xhtml+xml", extractHTML, true},
+		{"text/plain", extractText, true},
+		{"application/xml", extractXML, true},
+		{"application/atom+xml", extractFeed, true},
+		{"application/vnd.custom+xml", extractXML, true},
+		{"application/ld+json", extractJSON, true},
+		{"text/markdown", extractText, true},
+		{"video/mp4", extractText, true},
+		{"image/svg+xml", extractXML, true},
+		{"application/json", nil, true},
+		{"image/png", nil, true},
+		{"application/octet-stream", nil, true},
+		{"application/zip", nil, false},
+	}
+	for _, tc := range tests {
+		ext, known := wf.extractorFor(tc.mediaType)
+		if reflect.ValueOf(ext).Pointer() !=
+			reflect.ValueOf(tc.ext).Pointer() || known != tc.known {
+			t.Errorf("%s: unexpected extractor, known %t\n", tc.mediaType,
+				known)
+		}
+	}
+}
+
+// Returns the sorted words an extractor finds in a document.
+func extractWords(t *testing.T, ext Extractor, doc string) string {
+	wf := testFinder(t)
+	sr := searchRecord{url: "http://example.com/"}
+	wds, _, _, _ := ext(context.Background(), sr,
+		bufio.NewReader(strings.NewReader(doc)), "", wf)
+	var res []string
+	for k := range wds.counts {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return strings.Join(res, " ")
+}
+
+// Test that only the text of XML and the string values of JSON are
+// counted, not the markup or field names.
+func TestStructuredExtractors(t *testing.T) {
+	*minLen = 4
+	*maxLen = 0
+	got := extractWords(t, extractXML, `<?xml version="1.0" encoding="ISO-8859-1"?>
+		<catalog><bookitem genre="fiction"><title>Moonlit garden</title>
+		<!-- commented --><![CDATA[hidden treasure]]></bookitem></catalog>`)
+	if got != "Moonlit garden hidden treasure" {
+		t.Errorf("unexpected XML words: %q\n", got)
+	}
+
+	got = extractWords(t, extractJSON, `{"headline": "Storm warning",
+		"tags": ["weather", {"label": "coastal"}], "count": 12,
+		"nested": {"body": "floods expected", "draft": false},
+		"author": null}`)
+	if got != "Storm coastal expected floods warning weather" {
+		t.Errorf("unexpected JSON words: %q\n", got)
+	}
+}
+
+// Test that skipped and unknown media types aren't read, and that
+// unknown types are reported.
+func TestMediaTypeStats(t *testing.T) {
+	pages := map[string][2]string{
+		"/":     {"text/html", `<a href="/img">i</a> <a href="/zip">z</a> <a href="/data">d</a> pagetext`},
+		"/img":  {"image/png", "imagebytes"},
+		"/zip":  {"application/zip", "zipbytes"},
+		"/data": {"application/json", `{"key": "jsontext"}`},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		p := pages[r.URL.Path]
+		w.Header().Set("Content-Type", p[0])
+		w.Write([]byte(p[1]))
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("URL parse failed: %v\n", err)
+	}
+	*minLen = 5
+	*maxLen = 0
+	finder, err := newWordFinder([]*url.URL{u}, newFormatter())
+	if err != nil {
+		t.Fatalf("creating finder failed: %v\n", err)
+	}
+	finder.run(context.Background())
+
+	expected := map[string]int{"pagetext": 1, "jsontext": 1, "imagebytes": 0,
+		"zipbytes": 0}
+	for k, v := range expected {
+		if finder.words.counts[k] != v {
+			t.Errorf("expected %d counts of '%s', got %d\n", v, k,
+				finder.words.counts[k])
+		}
+	}
+	st := finder.getStats()
+	if st.skipped != 1 || len(st.unknown) != 1 ||
+		st.unknown["application/zip"] != 1 {
+		t.Errorf("unexpected media type stats: %d, %v\n", st.skipped,
+			st.unknown)
+	}
+}
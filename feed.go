@@ -0,0 +1,132 @@
+// Many blogs publish their posts as RSS or Atom feeds, which pages link
+// to with <link rel="alternate">.  The feed extractor counts the words
+// in a feed's titles, summaries and content, which usually hold escaped
+// HTML, and follows the link of each entry, within the site's scope,
+// so that the full posts are crawled too.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// The media types of feeds that pages link to.
+var feedTypes = map[string]bool{
+	"application/rss+xml":  true,
+	"application/atom+xml": true,
+}
+
+// The elements of RSS and Atom feeds whose text we count.
+var feedTextElements = map[string]bool{
+	"title":       true,
+	"subtitle":    true,
+	"description": true, // RSS
+	"summary":     true, // Atom
+	"content":     true, // Atom
+	"encoded":     true, // RSS content:encoded
+}
+
+// Reports whether the start of an XML document looks like a feed, for
+// servers that give feeds a generic XML media type.
+func isFeed(body *bufio.Reader) bool {
+	peek, _ := body.Peek(charsetPeekSize)
+	return bytes.Contains(peek, []byte("<rss")) ||
+		bytes.Contains(peek, []byte("<feed")) ||
+		bytes.Contains(peek, []byte("<rdf:RDF"))
+}
+
+func extractFeed(ctx context.Context, sr searchRecord, body *bufio.Reader,
+	contentType string, wf *WordFinder) (*wordCounts, []string,
+	pageDirectives, error) {
+	wds := newWordCounts(0, wf.weights != nil)
+	var links []string
+	baseURL, err := url.Parse(sr.url)
+	if err != nil {
+		log.Printf("Warning: URL parse error: %v\n", err)
+		return nil, nil, pageDirectives{}, nil
+	}
+
+	// Follows an entry's link, if it's within the scope.
+	addLink := func(href string) {
+		if !*feeds {
+			return
+		}
+		if u := resolveLink(baseURL, href); u != nil &&
+			wf.scope.containsURL(u) {
+			links = append(links, wf.canon.canonical(u))
+		}
+	}
+
+	dec := wf.xmlDecoder(sr, body, contentType)
+
+	// The element whose text we're collecting, if any.  Atom content
+	// may be XHTML, so the text of any elements inside it is
+	// collected too.
+	var capture string
+	var text strings.Builder
+	inEntry := false
+	for ctx.Err() == nil {
+		tok, err := dec.Token()
+		if err != nil {
+			return wds, links, pageDirectives{}, readError(err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			switch {
+			case capture != "":
+			case name == "item" || name == "entry":
+				inEntry = true
+			case name == "link" && inEntry:
+				// Atom links are attributes, and RSS links text.
+				href, rel := xmlAttr(t, "href"), xmlAttr(t, "rel")
+				if href != "" && (rel == "" || rel == "alternate") {
+					addLink(href)
+				} else if href == "" {
+					capture = name
+				}
+			case feedTextElements[name]:
+				capture = name
+			}
+		case xml.CharData:
+			if capture != "" {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			name := t.Name.Local
+			switch {
+			case name == capture && name == "link":
+				addLink(text.String())
+			case name == capture:
+				fw, _, _, _ := sr.processHTML(ctx,
+					strings.NewReader(text.String()), wf)
+				if fw != nil {
+					wds.merge(fw)
+				}
+			case name == "item" || name == "entry":
+				inEntry = false
+			}
+			if name == capture {
+				capture = ""
+				text.Reset()
+			}
+		}
+	}
+	return wds, links, pageDirectives{}, nil
+}
+
+// Returns the value of the element's attribute with the given local
+// name, or "" if it has none.
+func xmlAttr(se xml.StartElement, name string) string {
+	for _, a := range se.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Test that feeds linked from a page are fetched, that the words in
+// their entries are counted with any HTML removed, and that the entry
+// links within the scope are followed.
+func TestFeeds(t *testing.T) {
+	rss := `<?xml version="1.0"?>
+		<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/">
+		<channel><title>Channeltitle</title><link>/</link>
+		<item><title>Firstentry</title><link>/post1</link>
+		<description>&lt;p&gt;Escaped &lt;b&gt;summarytext&lt;/b&gt;&lt;/p&gt;</description>
+		<content:encoded><![CDATA[<div>Encodedbody <script>scriptword</script></div>]]></content:encoded>
+		</item>
+		<item><title>Offsite</title><link>http://elsewhere.invalid/post</link></item>
+		</channel></rss>`
+	atom := `<?xml version="1.0" encoding="utf-8"?>
+		<feed xmlns="http://www.w3.org/2005/Atom"><title>Atomtitle</title>
+		<entry><title type="html">Atom &amp;lt;i&amp;gt;entrytitle&amp;lt;/i&amp;gt;</title>
+		<link rel="edit" href="/edit2"/><link href="/post2"/>
+		<summary>Atomsummary</summary>
+		<content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml">
+		<p>Xhtmlcontent</p></div></content></entry></feed>`
+
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	pages := map[string][2]string{
+		"/": {"text/html", `<html><head>
+			<link rel="alternate" type="application/rss+xml" href="/feed.xml">
+			<link rel="alternate" type="application/atom+xml" href="/atom">
+			</head><body>Homepage</body></html>`},
+		"/feed.xml": {"text/xml", rss},
+		"/atom":     {"application/atom+xml", atom},
+		"/post1":    {"text/html", "Firstpost"},
+		"/post2":    {"text/html", "Secondpost"},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		p := pages[r.URL.Path]
+		w.Header().Set("Content-Type", p[0])
+		w.Write([]byte(p[1]))
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("URL parse failed: %v\n", err)
+	}
+	*minLen = 5
+	*maxLen = 0
+	finder, err := newWordFinder([]*url.URL{u}, newFormatter())
+	if err != nil {
+		t.Fatalf("creating finder failed: %v\n", err)
+	}
+	finder.run(context.Background())
+
+	for _, w := range []string{"Homepage", "Channeltitle", "Firstentry",
+		"Escaped", "summarytext", "Encodedbody", "Atomtitle", "entrytitle",
+		"Atomsummary", "Xhtmlcontent", "Firstpost", "Secondpost"} {
+		if finder.words.counts[w] != 1 {
+			t.Errorf("expected one count of '%s', got %d\n", w,
+				finder.words.counts[w])
+		}
+	}
+	for w := range finder.words.counts {
+		if strings.ContainsAny(w, "<>") || w == "scriptword" {
+			t.Errorf("unexpected word '%s'\n", w)
+		}
+	}
+	if hits["/edit2"] != 0 {
+		t.Errorf("followed an Atom link that isn't the entry's page\n")
+	}
+}
@@ -5,7 +5,9 @@ package main
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
@@ -19,21 +21,83 @@ import (
 // The WordFinder controls the overall processing.  It collates the
 // results to get the longest word at the end.
 type WordFinder struct {
-	words     map[string]int
-	errRecs   []searchRecord
-	target    string
-	startURL  *url.URL
-	filter    chan ([]string)
-	interrupt bool
-	mu        sync.Mutex
-	client    *http.Client
-	fmtr      *formatter
+	words      *wordCounts
+	weights    map[string]float64 // nil unless weighting is on
+	errRecs    []searchRecord
+	scope      *Scope
+	canon      *canonicalizer
+	skipElems  map[string]bool
+	linkSrcs   map[string]bool
+	allowTypes []string
+	denyTypes  []string
+	policy     textPolicy
+	startURLs  []*url.URL
+	filter     chan (pageLinks)
+	interrupt  bool
+	mu         sync.Mutex
+	client     *http.Client
+	fmtr       *formatter
+
+	// The robots.txt rules for each host, and the links we skipped
+	// because of them.
+	robots     *robotsCache
+	disallowed []string
+
+	// The canonical URLs of the pages whose words we've counted.
+	counted map[string]bool
+
+	// Per-host request pacing, and the statistics for the run.
+	limiter *hostLimiter
+	stats   crawlStats
+}
+
+// Statistics gathered over the course of the run.  The fields updated
+// by the workers are protected by the WordFinder's mutex.
+type crawlStats struct {
+	pages     uint
+	depths    []uint // pages processed at each depth
+	excluded  uint   // distinct links rejected by the include/exclude filters
+	noindex   uint   // pages whose words we were asked not to count
+	nofollow  uint   // pages whose links we were asked not to follow
+	dupes     uint   // pages whose canonical page was already counted
+	sitemap   uint   // pages seeded from sitemaps
+	retries   uint   // fetches retried after transient errors
+	rateWait  time.Duration
+	delayed   int
+	overloads int
+	charsets  map[string]uint // pages found in each charset
+	skipped   uint            // pages of media types that are skipped
+	unknown   map[string]uint // pages of each unknown media type
+	pdfs      uint            // PDF documents whose text was extracted
+	pdfFailed uint            // PDF documents we couldn't read
+}
+
+// A crawlTask is a link for a worker to process, along with its
+// depth, which is the number of links followed from the start page.
+type crawlTask struct {
+	url     string
+	depth   int
+	attempt int
+}
+
+// The pageLinks are the links found on a page at the given depth,
+// which the workers send back to the run loop.  If fetching the page
+// failed in a way that is worth retrying, retry holds the next attempt,
+// and failed the record of this one, which is reported as an error if
+// the crawl stops before the retry can be made.
+type pageLinks struct {
+	depth  int
+	links  []string
+	retry  *crawlTask
+	failed *searchRecord
 }
 
 // The following two structs are for sorting the frequency map.
 type kvPair struct {
-	key   string
-	value int
+	key    string
+	value  int
+	weight float64
+	form   string // the most common surface form of the key
 }
 
 type kvSorter []kvPair
@@ -41,36 +105,84 @@ type kvSorter []kvPair
 // Ensure we've implemented all the sort.Interface methods.
 var _ sort.Interface = (*kvSorter)(nil)
 
-// Creates a new WordFinder with the given start URL.
-func newWordFinder(startURL *url.URL, f *formatter) *WordFinder {
+// Creates a new WordFinder with the given start URLs, which share
+// one frontier and one visited set.
+func newWordFinder(startURLs []*url.URL, f *formatter) (*WordFinder, error) {
+
+	// Restrict crawling to within the initial sites, as defined by
+	// the configured scope.
+	var extra []string
+	if *allowHosts != "" {
+		extra = strings.Split(*allowHosts, ",")
+	}
+	scope, err := newScope(*scopeFlag, startURLs, extra)
+	if err != nil {
+		return nil, err
+	}
+
+	// Words in titles and headings may count for more.
+	var weights map[string]float64
+	if *weighted || *weightsFile != "" {
+		weights, err = loadWeights(*weightsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The elements whose links we follow.
+	linkSrcs, err := linkSourceSet(*linkSources)
+	if err != nil {
+		return nil, err
+	}
 
-	// Restrict crawling to within the initial site.  Thus a
-	// site that has our host in it is a link we'll follow
-	// (we don't need the www part to compare).
-	target := startURL.Hostname()
-	if strings.HasPrefix(target, "www.") {
-		target = target[4:]
+	// PDF text extraction is optional.
+	deny := mediaTypeList(*denyTypes)
+	if !*pdfText {
+		deny = append(deny, "application/pdf")
 	}
 
-	// The one client is thread safe for use by the scanners.
+	// The one client is thread safe for use by the scanners.  Each
+	// redirect is a request of its own, to be paced like any other.
+	var wf *WordFinder
 	client := &http.Client{
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			if !strings.HasSuffix(req.URL.Hostname(), target) {
+			if !scope.containsURL(req.URL) {
 				return http.ErrUseLastResponse
 			}
-			return nil
+			return wf.waitTurn(req.Context(), req.URL)
 		},
 		Timeout: time.Duration(*connTimeout) * time.Second,
 	}
 
-	return &WordFinder{
-		words:    make(map[string]int, *dictSize),
-		startURL: startURL,
-		target:   target,
-		filter:   make(chan []string),
-		client:   client,
-		fmtr:     f,
+	wf = &WordFinder{
+		words:     newWordCounts(*dictSize, weights != nil),
+		weights:   weights,
+		startURLs: startURLs,
+		scope:     scope,
+		canon: newCanonicalizer(strings.Split(*stripParams, ","),
+			*foldSlash),
+		skipElems:  elementSet(*skipElements),
+		linkSrcs:   linkSrcs,
+		allowTypes: mediaTypeList(*allowTypes),
+		denyTypes:  deny,
+		policy: textPolicy{
+			linkText:  *linkText,
+			altText:   *altText,
+			titleAttr: *titleText,
+			ariaLabel: *ariaText,
+			metaText:  *metaText,
+		},
+		filter:  make(chan pageLinks),
+		client:  client,
+		fmtr:    f,
+		robots:  newRobotsCache(client, *userAgent),
+		counted: make(map[string]bool),
+		limiter: newHostLimiter(*rate, *burst),
 	}
+
+	// Requests for robots.txt are paced like any others.
+	wf.robots.pace = wf.waitTurn
+	return wf, nil
 }
 
 // This is the main run loop from the crawler.  It creates the
@@ -83,18 +195,19 @@ func (wf *WordFinder) run(ctx context.Context) {
 	// Create and launch the goroutines that crawl and
 	// gather word counts.
 	visited := make(map[string]bool)
+	excluded := make(map[uint64]bool)
 
 	// Create the channel for the goroutines to get tasks from.
 	// This is either a standard unbuffered channel, or a
 	// "virtual" unlimited length channel.  Usin a fixed length
 	// buffered channel doesn't help here, as there is no good
 	// way to predict a good buffer size.
-	var ssend chan<- string
-	var srecv <-chan string
+	var ssend chan<- crawlTask
+	var srecv <-chan crawlTask
 	if *unlimitedChan {
-		ssend, srecv = unlimitedStringChannel(0)
+		ssend, srecv = unlimitedChannel[crawlTask](0)
 	} else {
-		search := make(chan string)
+		search := make(chan crawlTask)
 		ssend = search
 		srecv = search
 	}
@@ -104,33 +217,110 @@ func (wf *WordFinder) run(ctx context.Context) {
 		go func() {
 			defer wg.Done()
 
-			for rec := range srecv {
-				sr := searchRecord{url: rec}
+			for task := range srecv {
+				sr := searchRecord{url: task.url, depth: task.depth,
+					attempt: task.attempt}
 				sr.processLink(ctx, wf)
 			}
 		}()
 	}
 
+	// The seeds are the start URLs, plus the pages from the sites'
+	// sitemaps if we were asked to read them.
+	var seeds []string
+	for _, u := range wf.startURLs {
+		seeds = append(seeds, wf.canon.canonical(u))
+	}
+	if *sitemap {
+		seeds = append(seeds, wf.sitemapURLs(ctx)...)
+	}
+
 	// The function definition for the main processing loop.
-	loopFunc := func(tasks chan<- string, filter <-chan []string) {
+	loopFunc := func(tasks chan<- crawlTask, filter <-chan pageLinks) {
 		var tot uint
+		var cnt int
 		limit := *iter
 
-		// Prime the pump by feeding start url into the work channel.
-		tasks <- wf.startURL.String()
+		// Every link sent into the "task" channel adds one to the
+		// counter.  The loop decrements the count by one at the end
+		// of each iteration.
+		enqueue := func(task crawlTask) {
+			cnt++
+			if *unlimitedChan {
+				// Using the unlimited buffering channel.
+				tasks <- task
+			} else {
+				// Standard channel.  Use goroutine if blocked.
+				select {
+				case tasks <- task:
+				default:
+					go func() {
+						tasks <- task
+					}()
+				}
+			}
+		}
+
+		// Prime the pump by feeding the seeds into the work channel,
+		// provided the sites let us in at all.  The start URLs are
+		// always crawled, but the sitemap pages are subject to the
+		// filters, like any other link, and only counted if they
+		// are queued.
+		for i, seed := range seeds {
+			fromSitemap := i >= len(wf.startURLs)
+			if visited[seed] || (fromSitemap && !wf.wanted(seed)) {
+				continue
+			}
+			visited[seed] = true
+			if wf.allowed(ctx, seed) {
+				enqueue(crawlTask{url: seed})
+				if fromSitemap {
+					wf.stats.sitemap++
+				}
+			}
+		}
 
 		// Loop until there is no more work.  By keeping a count, we
 		// know when there is no more work left.  The loop decrements
 		// once each time through to balance the result of adding a new
 		// search task.
-		for cnt := 1; cnt > 0; cnt-- {
+		for ; cnt > 0; cnt-- {
 			// At the start of each loop iteration, we block on the
 			// "filter" channel, which contains results from each
 			// page scan (all the links found for a page are in a
 			// single slice).  Note since we are inside the loop,
 			// we are guaranteed to get more reads,  and the
 			// interrupt-handling preserves this invariant.
-			l := <-filter
+			pl := <-filter
+
+			// Pages that failed transiently go back in the queue
+			// after a delay, which takes the place of this result
+			// in the count, so that only the final result for
+			// each page is counted as processed.  The delay is
+			// abandoned if the user cancels, so the drain isn't
+			// held up.  If we're stopping anyway, the failure is
+			// reported as an error instead.
+			if pl.retry != nil {
+				if (limit == 0 || tot < limit) && ctx.Err() == nil {
+					wf.stats.retries++
+					cnt++
+					task := *pl.retry
+					go func() {
+						t := time.NewTimer(retryDelay(task.attempt))
+						defer t.Stop()
+						select {
+						case <-t.C:
+						case <-ctx.Done():
+						}
+						tasks <- task
+					}()
+					continue
+				}
+				wf.mu.Lock()
+				wf.errRecs = append(wf.errRecs, *pl.failed)
+				wf.mu.Unlock()
+			}
+
 			tot++
 			if limit > 0 && tot > limit {
 				wf.interrupt = true
@@ -149,36 +339,52 @@ func (wf *WordFinder) run(ctx context.Context) {
 				break
 			}
 
+			for len(wf.stats.depths) <= pl.depth {
+				wf.stats.depths = append(wf.stats.depths, 0)
+			}
+			wf.stats.depths[pl.depth]++
+
+			// Don't go any deeper than we were asked to, or
+			// follow links at all if we're just reading the pages
+			// listed in the sitemaps.
+			depth := pl.depth + 1
+			if !*followLinks || (*maxDepth >= 0 && depth > *maxDepth) {
+				continue
+			}
+
 			// Process the links seen in the page scan read from
 			// the channel.
-			for _, link := range l {
+			for _, link := range pl.links {
 				// Don't visit the same link twice.
 				if visited[link] {
 					continue
 				}
-				visited[link] = true
 
-				// Every link sent into the "task" channel adds
-				// one to the counter.  The loop decremnts the
-				// count by one at the end of each iteration.
-				cnt++
-				if *unlimitedChan {
-					// Using the unlimited buffering channel.
-					tasks <- link
-				} else {
-					// Standard channel.  Use goroutine if blocked.
-					select {
-					case tasks <- link:
-					default:
-						link := link
-						go func() {
-							tasks <- link
-						}()
+				// Filtered links aren't marked visited, so that
+				// sites with huge numbers of them don't bloat the
+				// visited map.  Only a hash of each is kept, so
+				// that a link repeated on every page, such as in
+				// the navigation, is counted as excluded once.
+				if !wf.wanted(link) {
+					h := fnv.New64a()
+					h.Write([]byte(link))
+					if sum := h.Sum64(); !excluded[sum] {
+						excluded[sum] = true
+						wf.stats.excluded++
 					}
+					continue
+				}
+				visited[link] = true
+				if !wf.allowed(ctx, link) {
+					continue
 				}
+
+				enqueue(crawlTask{url: link, depth: depth})
 			}
 		}
 
+		wf.stats.pages = tot
+
 		if *memprofile != "" {
 			f, err := os.Create(*memprofile)
 			if err != nil {
@@ -204,6 +410,48 @@ func (wf *WordFinder) run(ctx context.Context) {
 	wg.Wait()
 }
 
+// Reports whether the link passes the include and exclude filters.
+// If there are any include patterns, the link must match one of them,
+// and it must not match any of the exclude patterns.
+func (wf *WordFinder) wanted(link string) bool {
+	if len(includes) == 0 && len(excludes) == 0 {
+		return true
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	if len(includes) > 0 && !includes.matches(u) {
+		return false
+	}
+	return !excludes.matches(u)
+}
+
+// Reports whether robots.txt lets us crawl the link, recording it
+// in the skipped list if not.  This is only called from the run loop,
+// so the list needs no locking until the workers are done.
+//
+// The first link to each host fetches its robots.txt here, paced like
+// any other request, so a slow or backed-off host holds up dispatch to
+// every host until the fetch is done.  That happens once per host, and
+// is the price of keeping disallowed links out of the queue, the
+// visited set and the page counts entirely, rather than having the
+// workers check them after they have been queued.
+func (wf *WordFinder) allowed(ctx context.Context, link string) bool {
+	if *ignoreRobots {
+		return true
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	if wf.robots.allowed(ctx, u) {
+		return true
+	}
+	wf.disallowed = append(wf.disallowed, link)
+	return false
+}
+
 // When a goroutine is finished processing a link, it transfers its
 // link and word count data to the finder.  We could eliminate the
 // mutex here and have the dictionary merge happen in the channel
@@ -211,42 +459,109 @@ func (wf *WordFinder) run(ctx context.Context) {
 // in the channel buffers or waiting goroutines, so this is a
 // time/sapce tradeoff, as merging the data here is fast.
 func (wf *WordFinder) addLinkData(ctx context.Context,
-	sr searchRecord, wds map[string]int, links []string) {
-	if (wds != nil && len(wds) > 0) || links != nil {
+	sr searchRecord, wds *wordCounts, links []string,
+	dirs pageDirectives) {
+
+	// Transient failures are retried, up to a limit, rather than
+	// being recorded as errors.
+	var retry *crawlTask
+	if sr.err != nil && sr.transient && sr.attempt < *retries &&
+		ctx.Err() == nil {
+		retry = &crawlTask{url: sr.url, depth: sr.depth,
+			attempt: sr.attempt + 1}
+	} else if sr.err != nil {
+		// Only append records with errors.
 		wf.mu.Lock()
+		wf.errRecs = append(wf.errRecs, sr)
+		wf.mu.Unlock()
+	}
 
-		// Only append records with errors.
-		if sr.err != nil {
-			wf.errRecs = append(wf.errRecs, sr)
+	if !wds.empty() || links != nil {
+		wf.mu.Lock()
+
+		// Pages that declare the same canonical page are
+		// copies of each other, so only count the first.
+		key := sr.url
+		if dirs.canonical != "" {
+			key = dirs.canonical
 		}
-		for k, v := range wds {
-			wf.words[k] += v
+		if !wds.empty() && wf.counted[key] {
+			wf.stats.dupes++
+		} else if !wds.empty() {
+			wf.counted[key] = true
+			wf.words.merge(wds)
+		}
+		wf.mu.Unlock()
+	}
+	if dirs.noindex || dirs.nofollow {
+		wf.mu.Lock()
+		if dirs.noindex {
+			wf.stats.noindex++
+		}
+		if dirs.nofollow {
+			wf.stats.nofollow++
 		}
 		wf.mu.Unlock()
 	}
 
-	sendData := func(filter chan<- []string) {
+	sendData := func(filter chan<- pageLinks) {
 		// Only create a new goroutine to send the link if the channel
 		// would block.  One way or another, we want to keep the thread
 		// available for processing.
+		pl := pageLinks{depth: sr.depth, links: links, retry: retry}
+		if retry != nil {
+			pl.failed = &sr
+		}
 		select {
 		case <-ctx.Done():
 			wf.interrupt = true
-			filter <- nil
-		case filter <- links:
+			filter <- pageLinks{depth: sr.depth, retry: pl.retry,
+				failed: pl.failed}
+		case filter <- pl:
 		default:
-			go func() { filter <- links }()
+			go func() { filter <- pl }()
 		}
 	}
 	sendData(wf.filter)
 }
 
+// Returns how long to wait before the given retry attempt.  The delay
+// doubles with each attempt, and is jittered so that a burst of
+// failures doesn't come back as a burst of retries.  A base delay of
+// zero means not to wait at all.
+func retryDelay(attempt int) time.Duration {
+	base := *retryBackoff
+	if base <= 0 {
+		return 0
+	}
+	shift := uint(max(attempt-1, 0))
+	d := base << shift
+	if shift >= 63 || d>>shift != base || d > maxBackoff {
+		d = maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
 // Show any errors and the top word counts.
 func (wf *WordFinder) getResults() []kvPair {
-	sorter := make(kvSorter, len(wf.words))
+	return topCounts(wf.words)
+}
+
+// Returns the top phrases, or nil if phrases aren't being counted.
+func (wf *WordFinder) getPhrases() []kvPair {
+	if wf.words.phrases == nil {
+		return nil
+	}
+	return topCounts(wf.words.phrases)
+}
+
+// Returns the top entries of a set of counts, up to the number
+// requested.
+func topCounts(wc *wordCounts) []kvPair {
+	sorter := make(kvSorter, len(wc.counts))
 	i := 0
-	for k, v := range wf.words {
-		sorter[i] = kvPair{k, v}
+	for k, v := range wc.counts {
+		sorter[i] = kvPair{k, v, wc.weights[k], k}
 		i++
 	}
 	sort.Sort(sorter)
@@ -254,6 +569,9 @@ func (wf *WordFinder) getResults() []kvPair {
 	if len(sorter) < cnt {
 		cnt = len(sorter)
 	}
+	for i := range sorter[:cnt] {
+		sorter[i].form = wc.form(sorter[i].key)
+	}
 	return sorter[:cnt]
 }
 
@@ -263,6 +581,96 @@ func (wf *WordFinder) getErrors() []searchRecord {
 	return wf.errRecs
 }
 
+// Waits for the rate limiter to let us make a request to the URL's
+// host, and records the time spent waiting.
+func (wf *WordFinder) waitTurn(ctx context.Context, u *url.URL) error {
+	var delay time.Duration
+	if !*ignoreRobots {
+		delay = wf.robots.crawlDelay(u)
+	}
+	d, err := wf.limiter.wait(ctx, u.Host, delay)
+	if d > 0 {
+		wf.mu.Lock()
+		wf.stats.rateWait += d
+		wf.stats.delayed++
+		wf.mu.Unlock()
+	}
+	return err
+}
+
+// Backs off the host of an overloaded response, or resets the back-off
+// when the host is fine again.  The host is the one we asked for, not
+// the one any redirects ended up at.
+func (wf *WordFinder) checkOverload(resp *http.Response) {
+	req := resp.Request
+	for req.Response != nil {
+		req = req.Response.Request
+	}
+	host := req.URL.Host
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		wf.limiter.overloaded(host,
+			parseRetryAfter(resp.Header.Get("Retry-After")))
+		wf.mu.Lock()
+		wf.stats.overloads++
+		wf.mu.Unlock()
+	default:
+		wf.limiter.succeeded(host)
+	}
+}
+
+// Records the charset a page was found to be in.
+func (wf *WordFinder) noteCharset(url, name string) {
+	if *verbose {
+		log.Printf("'%s': charset %s\n", url, name)
+	}
+	wf.mu.Lock()
+	if wf.stats.charsets == nil {
+		wf.stats.charsets = make(map[string]uint)
+	}
+	wf.stats.charsets[name]++
+	wf.mu.Unlock()
+}
+
+// Records a page that was skipped because of its media type, which
+// is either known to be skipped, or unknown.
+func (wf *WordFinder) noteSkippedType(mediaType string, known bool) {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	if known {
+		wf.stats.skipped++
+		return
+	}
+	if wf.stats.unknown == nil {
+		wf.stats.unknown = make(map[string]uint)
+	}
+	wf.stats.unknown[mediaType]++
+}
+
+// Records whether the text of a PDF document could be extracted.
+func (wf *WordFinder) notePDF(ok bool) {
+	wf.mu.Lock()
+	if ok {
+		wf.stats.pdfs++
+	} else {
+		wf.stats.pdfFailed++
+	}
+	wf.mu.Unlock()
+}
+
+// Returns the statistics for the run.
+func (wf *WordFinder) getStats() crawlStats {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	return wf.stats
+}
+
+// Returns the links that were skipped because robots.txt disallowed
+// them, or nil if there were none.
+func (wf *WordFinder) getDisallowed() []string {
+	return wf.disallowed
+}
+
 // The following methods are used to to sort the histogram by value.
 // Len is part of sort.Interface.
 func (kvs kvSorter) Len() int {
@@ -274,7 +682,11 @@ func (kvs kvSorter) Swap(i, j int) {
 	kvs[i], kvs[j] = kvs[j], kvs[i]
 }
 
-// Less is part of sort.Interface.
+// Less is part of sort.Interface.  Weighted totals come first, and
+// are all zero when weighting is off.
 func (kvs kvSorter) Less(i, j int) bool {
+	if kvs[i].weight != kvs[j].weight {
+		return kvs[j].weight < kvs[i].weight
+	}
 	return kvs[j].value < kvs[i].value
 }
@@ -2,4 +2,7 @@ module github.com/gdotgordon/site_word_freq
 
 go 1.21
 
-require golang.org/x/net v0.33.0
+require (
+	golang.org/x/net v0.33.0
+	golang.org/x/text v0.21.0
+)
@@ -0,0 +1,74 @@
+// Word lengths are measured in characters rather than bytes, so that
+// the length limits mean the same thing in every script.  A character
+// is either a Unicode code point (rune), or a user-perceived character
+// (grapheme cluster), in which a base letter and the marks combined
+// with it count as one.
+package main
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// The ways of measuring a word's length.
+var lengthUnits = map[string]func(string) int{
+	"runes":     utf8.RuneCountInString,
+	"graphemes": graphemeCount,
+}
+
+// Returns the length of the word in the configured unit.
+func wordLength(word string) uint {
+	if f, ok := lengthUnits[*lengthUnit]; ok {
+		return uint(f(word))
+	}
+	return uint(utf8.RuneCountInString(word))
+}
+
+// Counts the grapheme clusters in a word.  This is a simplification of
+// the rules in Unicode Annex #29 that covers what our tokenizer treats
+// as words (letters, marks and digits, but no emoji or punctuation):
+// marks, joiners and variation selectors extend the cluster before
+// them, as do the conjoining vowel and final Hangul jamo, and an Indic
+// virama joins the consonants either side of it into one conjunct.
+func graphemeCount(word string) int {
+	n := 0
+	joined := false
+	for _, r := range word {
+		switch {
+		case r == '\u200d' || indicLinkers[r]:
+			// A zero width joiner or virama also joins the
+			// next character.
+			joined = true
+			continue
+		case unicode.Is(unicode.M, r) || unicode.Is(unicode.Variation_Selector, r):
+			// Extends the current cluster.
+		case isHangulExtend(r):
+			// Extends a leading jamo or syllable.
+		case joined:
+		default:
+			n++
+		}
+		joined = false
+	}
+	if n == 0 && word != "" {
+		// A word made only of marks is still something.
+		n = 1
+	}
+	return n
+}
+
+// The viramas that form conjuncts, per the InCB=Linker property.
+var indicLinkers = map[rune]bool{
+	0x094d: true, // Devanagari
+	0x09cd: true, // Bengali
+	0x0acd: true, // Gujarati
+	0x0b4d: true, // Oriya
+	0x0c4d: true, // Telugu
+	0x0d4d: true, // Malayalam
+}
+
+// Reports whether the rune is a Hangul medial vowel or final consonant
+// jamo, which combine with the preceding jamo into one syllable.
+func isHangulExtend(r rune) bool {
+	return (r >= 0x1160 && r <= 0x11ff) || (r >= 0xd7b0 && r <= 0xd7ff)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+)
+
+// Test word lengths in runes and graphemes across scripts.
+func TestWordLength(t *testing.T) {
+	tests := []struct {
+		word      string
+		runes     int
+		graphemes int
+	}{
+		{"hello", 5, 5},
+		{"caf\u00e9", 4, 4},
+		{"cafe\u0301", 5, 4},
+		{"мир", 3, 3},
+		{"Москва", 6, 6},
+		{"λόγος", 5, 5},
+		{"日本語", 3, 3},
+		{"नमस्ते", 6, 3},
+		{"\u1100\u1161\u11a8", 3, 1},
+		{"\u0301", 1, 1},
+	}
+	for _, tc := range tests {
+		*lengthUnit = "runes"
+		if n := wordLength(tc.word); n != uint(tc.runes) {
+			t.Errorf("%q: expected %d runes, got %d\n", tc.word, tc.runes, n)
+		}
+		*lengthUnit = "graphemes"
+		if n := wordLength(tc.word); n != uint(tc.graphemes) {
+			t.Errorf("%q: expected %d graphemes, got %d\n", tc.word,
+				tc.graphemes, n)
+		}
+	}
+	*lengthUnit = "runes"
+}
+
+// Test that the length limits apply to characters rather than bytes,
+// and that composed and decomposed forms of a word are merged.
+func TestScanTextUnicode(t *testing.T) {
+	*minLen = 4
+	*maxLen = 5
+	defer func() { *maxLen = 0 }()
+	wds := newWordCounts(0, false)
+
+	// "мир" is 6 bytes but only 3 letters, and "日本語" 9 bytes;
+	// "αβγδε" is 10 bytes but 5 letters.
+	scanText("мир 日本語 αβγδε Москва", wds, 1)
+
+	// The same word, composed and decomposed.
+	scanText("caf\u00e9 cafe\u0301 CAFE\u0301", wds, 1)
+
+	expected := map[string]int{"αβγδε": 1, "caf\u00e9": 2, "CAF\u00c9": 1}
+	if len(wds.counts) != len(expected) {
+		t.Fatalf("unexpected words: %v\n", wds.counts)
+	}
+	for k, v := range expected {
+		if wds.counts[k] != v {
+			t.Errorf("expected %d counts of %q, got %d\n", v, k, wds.counts[k])
+		}
+	}
+
+	// Devanagari words keep their vowel signs, and are measured
+	// in graphemes if asked.
+	*lengthUnit = "graphemes"
+	defer func() { *lengthUnit = "runes" }()
+	*minLen, *maxLen = 3, 3
+	wds = newWordCounts(0, false)
+	scanText("नमस्ते दुनिया", wds, 1)
+	if wds.counts["नमस्ते"] != 1 || wds.counts["दुनिया"] != 1 {
+		t.Fatalf("unexpected words: %v\n", wds.counts)
+	}
+}
@@ -0,0 +1,67 @@
+// Pages lead on to other pages in more ways than <a href>: image maps,
+// frames, <link rel="next"> pagination, <meta http-equiv="refresh">
+// redirects and search forms that are submitted with GET.  The
+// -link_sources flag chooses which of these the crawler follows.
+// Forms are left out by default, as their actions are usually search
+// pages that lead everywhere and nowhere.
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The sources of links that are followed by default.
+const defaultLinkSources = "a,area,frame,iframe,next,prev,refresh"
+
+// All the sources of links we know how to follow.
+var knownLinkSources = map[string]bool{
+	"a": true, "area": true, "frame": true, "iframe": true,
+	"next": true, "prev": true, "refresh": true, "form": true,
+}
+
+// The elements whose attributes may hold links, or otherwise change
+// how they're resolved.
+var linkElements = map[string]bool{
+	"a": true, "area": true, "base": true, "form": true, "frame": true,
+	"iframe": true, "link": true, "meta": true,
+}
+
+// Parses a comma-separated list of link sources into a set.
+func linkSourceSet(list string) (map[string]bool, error) {
+	set := elementSet(list)
+	for s := range set {
+		if !knownLinkSources[s] {
+			return nil, fmt.Errorf("unknown link source '%s'", s)
+		}
+	}
+	return set, nil
+}
+
+// Returns the URL in the content of a <meta http-equiv="refresh">
+// tag, such as "5; url=/next", or "" if it has none.
+func refreshURL(content string) string {
+	_, rest, found := strings.Cut(content, ";")
+	if !found {
+		_, rest, found = strings.Cut(content, ",")
+	}
+	if !found {
+		return ""
+	}
+	rest = strings.TrimSpace(rest)
+	if len(rest) < 4 || !strings.EqualFold(rest[:3], "url") {
+		return ""
+	}
+	rest = strings.TrimSpace(rest[3:])
+	if !strings.HasPrefix(rest, "=") {
+		return ""
+	}
+	rest = strings.TrimSpace(rest[1:])
+	if len(rest) > 0 && (rest[0] == '\'' || rest[0] == '"') {
+		if end := strings.IndexByte(rest[1:], rest[0]); end >= 0 {
+			return rest[1 : end+1]
+		}
+		return rest[1:]
+	}
+	return rest
+}
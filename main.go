@@ -11,7 +11,9 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -21,9 +23,12 @@ import (
 	"os"
 	"os/signal"
 	"runtime/pprof"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
 const (
@@ -47,13 +52,105 @@ var (
 		"minimum word length to track (0 => no limit)")
 	maxLen = flag.Uint("max_len", 8,
 		"the maximum word length to track (0 => no limit)")
-	totWords   = flag.Uint("tot_words", 10, "show the top 'this many' words")
-	iter       = flag.Uint("iter", 0, "if > 0, stop ater this many iterations")
+	lengthUnit = flag.String("length_unit", "runes",
+		"unit of word length: runes (code points) or graphemes")
+	foldCase = flag.Bool("fold_case", false,
+		"if 'true', count words that differ only in case together")
+	stripAccents = flag.Bool("strip_accents", false,
+		"if 'true', count words that differ only in diacritics together")
+	surfaceForms = flag.Bool("surface_forms", false,
+		"if 'true', show the most common spelling of each normalized word")
+	tokenizerKind = flag.String("tokenizer", "unicode",
+		"how text is split into words: unicode or regexp")
+	joinHyphens = flag.Bool("join_hyphens", true,
+		"if 'true', hyphenated words such as e-mail are one word (unicode tokenizer)")
+	digits = flag.String("digits", "any",
+		"words with digits to count: any, mixed (not plain numbers) or none")
+	ngram = flag.Uint("ngram", 0,
+		"if > 1, also count phrases of this many words, not starting or ending with a stopword")
+	stemLang = flag.String("stem", "",
+		"if set, count words by their stem in this language (en)")
+	lang = flag.String("lang", "",
+		"comma-separated languages whose stopwords are dropped, e.g. 'en' ('none' => not even from phrase ends)")
+	stopwordsFile = flag.String("stopwords", "",
+		"file of extra stopwords to drop, separated by white space")
+	totWords = flag.Uint("tot_words", 10, "show the top 'this many' words")
+	iter     = flag.Uint("iter", 0, "if > 0, stop ater this many iterations")
+	maxDepth = flag.Int("max_depth", -1,
+		"maximum links to follow from the start page (-1 => no limit)")
 	pprofPort  = flag.Int("pprof_port", 0, "if non-zero, pprof server port")
 	cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 	memprofile = flag.String("memprofile", "", "write memory profile to this file")
+	verbose    = flag.Bool("verbose", false, "if 'true', log details of each page")
+	userAgent  = flag.String("user_agent", "site_word_freq/1.0",
+		"User-Agent header sent, also matched against robots.txt groups")
+	ignoreRobots = flag.Bool("ignore_robots", false,
+		"if 'true', crawl pages even if robots.txt disallows them")
+	rate = flag.Float64("rate", 0,
+		"maximum requests per second to each host (0 => no limit)")
+	burst     = flag.Int("burst", 1, "requests allowed in a burst to each host")
+	scopeFlag = flag.String("scope", "subdomains",
+		"hosts to crawl: exact, subdomains, domain or hosts")
+	allowHosts = flag.String("allow_hosts", "",
+		"comma-separated extra hosts to crawl in 'hosts' scope mode")
+	stripParams = flag.String("strip_params", defaultStripParams,
+		"comma-separated query parameters to strip from links ('*' suffix => prefix)")
+	foldSlash = flag.Bool("fold_slash", false,
+		"if 'true', treat paths with and without a trailing slash as the same")
+	ignoreDirectives = flag.Bool("ignore_directives", false,
+		"if 'true', ignore noindex, nofollow and canonical hints (for audits)")
+	sitemap = flag.Bool("sitemap", false,
+		"if 'true', also seed the crawl with the pages in the site's sitemaps")
+	followLinks = flag.Bool("follow_links", true,
+		"if 'false', only crawl the start and sitemap pages")
+	feeds = flag.Bool("feeds", true,
+		"if 'true', follow links to RSS and Atom feeds and their entries")
+	linkSources = flag.String("link_sources", defaultLinkSources,
+		"comma-separated sources of links to follow: a, area, frame, iframe, next, prev, refresh, form")
+	seedFile = flag.String("seeds", "",
+		"file of additional start URLs, one per line")
+	retries = flag.Int("retries", 2,
+		"times to retry a fetch after a network error, 429 or 5xx")
+	retryBackoff = flag.Duration("retry_backoff", 500*time.Millisecond,
+		"delay before the first retry, doubled for each one after")
+	skipElements = flag.String("skip_elements", defaultSkipElements,
+		"comma-separated HTML elements whose text is not counted")
+	allowTypes = flag.String("allow_types", "",
+		"comma-separated media types to read, as plain text if need be ('type/*' => any subtype)")
+	denyTypes = flag.String("deny_types", "",
+		"comma-separated media types never to read ('type/*' => any subtype)")
+	maxBodyBytes = flag.Int64("max_body_bytes", 32<<20,
+		"the most bytes of a response body to read (0 => no limit)")
+	headCheck = flag.Bool("head_check", false,
+		"if 'true', send a HEAD request first and skip pages over -max_body_bytes")
+	pdfText = flag.Bool("pdf", false,
+		"if 'true', extract the text of PDF documents")
+	maxPDFBytes = flag.Int64("max_pdf_bytes", 16<<20,
+		"the largest PDF document to extract text from")
+	linkText  = flag.Bool("link_text", false, "if 'true', count the text of links")
+	altText   = flag.Bool("alt_text", false, "if 'true', count image alt text")
+	titleText = flag.Bool("title_text", false,
+		"if 'true', count the text of title attributes")
+	ariaText = flag.Bool("aria_text", false,
+		"if 'true', count the text of aria-label attributes")
+	metaText = flag.Bool("meta_text", false,
+		"if 'true', count <meta> description and keywords text")
+	weighted = flag.Bool("weighted", false,
+		"if 'true', weight words in titles, headings, etc. and rank by weight")
+	weightsFile = flag.String("weights", "",
+		"file of '<element> <weight>' lines (implies -weighted)")
 )
 
+// The repeatable URL filter flags.
+var includes, excludes patternList
+
+func init() {
+	flag.Var(&includes, "include",
+		"only crawl links matching this pattern (repeatable; glob, or re:regexp)")
+	flag.Var(&excludes, "exclude",
+		"don't crawl links matching this pattern (repeatable; glob, or re:regexp)")
+}
+
 // A formatter for messages intended for stdout.
 type formatter struct {
 	isTTY  bool
@@ -63,7 +160,7 @@ type formatter struct {
 
 func main() {
 	flag.Parse()
-	if flag.NArg() < 1 {
+	if flag.NArg() < 1 && *seedFile == "" {
 		log.Fatal(fmt.Errorf("%s: missing start URL", os.Args[0]))
 		os.Exit(1)
 	}
@@ -75,12 +172,57 @@ func main() {
 		os.Exit(1)
 	}
 
-	startURL := flag.Arg(0)
-	surl, err := url.Parse(startURL)
+	if _, ok := lengthUnits[*lengthUnit]; !ok {
+		log.Fatal(fmt.Errorf("%s: unknown length unit '%s'", os.Args[0],
+			*lengthUnit))
+	}
+
+	if _, ok := stemmers[*stemLang]; *stemLang != "" && !ok {
+		log.Fatal(fmt.Errorf("%s: no stemmer for language '%s'", os.Args[0],
+			*stemLang))
+	}
+
+	var err error
+	tokenizer, err = newTokenizer(*tokenizerKind, *joinHyphens, *digits)
 	if err != nil {
-		log.Fatal(fmt.Errorf("%s: The url '%s' is not syntactically valid",
-			os.Args[0], startURL))
-		os.Exit(1)
+		log.Fatal(fmt.Errorf("%s: %v", os.Args[0], err))
+	}
+	stopwords, err = loadStopwords(*lang, *stopwordsFile)
+	if err != nil {
+		log.Fatal(fmt.Errorf("%s: %v", os.Args[0], err))
+	}
+	if *ngram > 1 {
+		phraseLang := *lang
+		if phraseLang == "" {
+			phraseLang = "en"
+		}
+		phraseStopwords, err = loadStopwords(phraseLang, *stopwordsFile)
+		if err != nil {
+			log.Fatal(fmt.Errorf("%s: %v", os.Args[0], err))
+		}
+	}
+
+	startURLs := flag.Args()
+	if *seedFile != "" {
+		seeds, err := readSeeds(*seedFile)
+		if err != nil {
+			log.Fatal(fmt.Errorf("%s: %v", os.Args[0], err))
+		}
+		startURLs = append(startURLs, seeds...)
+	}
+	var surls []*url.URL
+	for _, startURL := range startURLs {
+		surl, err := url.Parse(startURL)
+		if err != nil || !surl.IsAbs() {
+			log.Fatal(fmt.Errorf("%s: The url '%s' is not syntactically valid",
+				os.Args[0], startURL))
+			os.Exit(1)
+		}
+		surls = append(surls, surl)
+	}
+	if len(surls) == 0 {
+		log.Fatal(fmt.Errorf("%s: no start URLs in '%s'", os.Args[0],
+			*seedFile))
 	}
 
 	if *pprofPort != 0 {
@@ -107,14 +249,17 @@ func main() {
 	// to a file.
 	formatter := newFormatter()
 
-	finder := newWordFinder(surl, formatter)
+	finder, err := newWordFinder(surls, formatter)
+	if err != nil {
+		log.Fatal(fmt.Errorf("%s: %v", os.Args[0], err))
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Signal handlers for orderly shutdown.  Handle SIGINT and
 	// SIGTERM for now.
 	go func() {
-		ch := make(chan os.Signal)
+		ch := make(chan os.Signal, 1)
 		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 		sig := <-ch
 		l := outputLength - len(sig.String())
@@ -132,17 +277,77 @@ func showStatus(finder *WordFinder) {
 			"Note: process was interrupted, results are partial.")
 	}
 
-	elist := finder.getErrors()
+	// Pages over the size limit are listed apart from the other errors.
+	var elist, large []searchRecord
+	for _, r := range finder.getErrors() {
+		if errors.Is(r.err, errBodyTooLarge) {
+			large = append(large, r)
+		} else {
+			elist = append(elist, r)
+		}
+	}
 	if elist == nil {
 		fmt.Printf("%-*.*s\n", outputLength, outputLength,
 			"No errors occurred in run.")
 	} else {
 		for _, r := range elist {
-			fmt.Printf("'%s': error occurred: %s\n", r.url, r.err.Error())
+			if r.attempt > 0 {
+				fmt.Printf("'%s': error occurred after %d attempts: %s\n",
+					r.url, r.attempt+1, r.err.Error())
+			} else {
+				fmt.Printf("'%s': error occurred: %s\n", r.url, r.err.Error())
+			}
 		}
 	}
 	fmt.Println()
 
+	if len(large) > 0 {
+		fmt.Printf("%d pages larger than %d bytes:\n", len(large),
+			*maxBodyBytes)
+		for _, r := range large {
+			fmt.Printf("  %s: %s\n", r.url, r.err.Error())
+		}
+		fmt.Println()
+	}
+
+	if skipped := finder.getDisallowed(); len(skipped) > 0 {
+		fmt.Printf("%d links skipped, disallowed by robots.txt:\n",
+			len(skipped))
+		for _, s := range skipped {
+			fmt.Printf("  %s\n", s)
+		}
+		fmt.Println()
+	}
+
+	st := finder.getStats()
+	fmt.Printf("Pages processed: %d\n", st.pages)
+	for d, n := range st.depths {
+		fmt.Printf("  depth %d: %d\n", d, n)
+	}
+	if *sitemap {
+		fmt.Printf("Pages seeded from sitemaps: %d\n", st.sitemap)
+	}
+	fmt.Printf("Links excluded by filters: %d\n", st.excluded)
+	fmt.Printf("Pages marked noindex: %d, nofollow: %d, canonical duplicates: %d\n",
+		st.noindex, st.nofollow, st.dupes)
+	fmt.Printf("Fetches retried: %d\n", st.retries)
+	fmt.Printf("Rate limit wait: %v total over %d requests, %d overloads\n",
+		st.rateWait.Round(time.Millisecond), st.delayed, st.overloads)
+	fmt.Printf("Words dropped as stopwords: %d\n", finder.words.stopped)
+	if len(st.charsets) > 0 {
+		fmt.Printf("Pages by charset:%s\n", formatTally(st.charsets))
+	}
+	fmt.Printf("Pages skipped by media type: %d\n", st.skipped)
+	if *pdfText {
+		fmt.Printf("PDF documents processed: %d, failed: %d\n", st.pdfs,
+			st.pdfFailed)
+	}
+	if len(st.unknown) > 0 {
+		fmt.Printf("Pages of unknown media types:%s\n",
+			formatTally(st.unknown))
+	}
+	fmt.Println()
+
 	res := finder.getResults()
 	if *maxLen > 0 {
 		fmt.Printf("Top %d totals for words of length %d to %d:\n",
@@ -152,8 +357,61 @@ func showStatus(finder *WordFinder) {
 			*totWords, *minLen)
 	}
 	for i, kv := range res {
-		fmt.Printf("[%d] %s: %d\n", i+1, kv.key, kv.value)
+		if finder.weights != nil {
+			fmt.Printf("[%d] %s: %.1f weighted, %d raw\n", i+1, kv.form,
+				kv.weight, kv.value)
+		} else {
+			fmt.Printf("[%d] %s: %d\n", i+1, kv.form, kv.value)
+		}
+	}
+
+	if phrases := finder.getPhrases(); phrases != nil {
+		fmt.Println()
+		fmt.Printf("Top %d phrases of %d words:\n", *totWords, *ngram)
+		for i, kv := range phrases {
+			if finder.weights != nil {
+				fmt.Printf("[%d] %s: %.1f weighted, %d raw\n", i+1, kv.form,
+					kv.weight, kv.value)
+			} else {
+				fmt.Printf("[%d] %s: %d\n", i+1, kv.form, kv.value)
+			}
+		}
+	}
+}
+
+// Formats a tally as " name count" for each name, in name order.
+func formatTally(tally map[string]uint) string {
+	var names []string
+	for name := range tally {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&sb, " %s %d", name, tally[name])
+	}
+	return sb.String()
+}
+
+// Reads start URLs from a file, one per line.  Blank lines and lines
+// starting with '#' are skipped.
+func readSeeds(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var seeds []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		seeds = append(seeds, line)
 	}
+	return seeds, sc.Err()
 }
 
 func newFormatter() *formatter {
@@ -0,0 +1,62 @@
+// Phrases are runs of consecutive words, such as "machine learning",
+// counted alongside the single words when -ngram is set.  A phrase is
+// only made from words in the same block of text, so it never spans
+// an element boundary or a line of plain text, or runs across
+// punctuation.
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Counts the phrases of *ngram words in the text, given the spans of
+// its words.  Every word can be part of a phrase, however short,
+// but a phrase that starts or ends with a phrase stopword is skipped,
+// so "of the" isn't counted while "bill of rights" is.
+func scanPhrases(text string, spans []span, phrases *wordCounts,
+	weight float64) {
+	n := int(*ngram)
+	run := 0 // consecutive words that can be joined into phrases
+	for i, sp := range spans {
+		w := text[sp.start:sp.end]
+		if strings.IndexByte(w, '_') >= 0 {
+			run = 0
+			continue
+		}
+		if run > 0 && !joined(text[spans[i-1].end:sp.start]) {
+			run = 0
+		}
+		run++
+		if run < n {
+			continue
+		}
+		first := spans[i-n+1]
+		if isPhraseStopword(text[first.start:first.end]) ||
+			isPhraseStopword(w) {
+			continue
+		}
+		keys := make([]string, 0, n)
+		forms := make([]string, 0, n)
+		for _, p := range spans[i-n+1 : i+1] {
+			s := text[p.start:p.end]
+			keys = append(keys, normalizeWord(s))
+			forms = append(forms, s)
+		}
+		phrases.add(strings.Join(keys, " "), strings.Join(forms, " "),
+			weight)
+	}
+}
+
+// Reports whether the text between two words keeps them in the same
+// phrase: white space, hyphens and apostrophes do, so unless hyphenated
+// words are joined, "state-of-the-art" is four words in a row, while
+// anything else ends the phrase.
+func joined(gap string) bool {
+	for _, r := range gap {
+		if !unicode.IsSpace(r) && !strings.ContainsRune("-‐'’", r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// Test that phrases are counted within blocks of text, and not across
+// punctuation or element boundaries, or starting or ending with a
+// stopword.
+func TestPhrases(t *testing.T) {
+	defer func() { *ngram, phraseStopwords = 0, nil }()
+	*minLen = 5
+	*maxLen = 0
+	*ngram = 2
+	var err error
+	if phraseStopwords, err = loadStopwords("en", ""); err != nil {
+		t.Fatalf("loading stopwords failed: %v\n", err)
+	}
+
+	wf := testFinder(t)
+	sr := searchRecord{url: "http://example.com/"}
+	wds, _, _, _ := sr.processHTML(context.Background(), strings.NewReader(
+		`<p>Free shipping on all orders.  Free shipping, today!</p>
+		<p>Our machine <b>learning</b> team does machine learning
+		and state-of-the-art work.</p>`), wf)
+
+	expected := map[string]int{
+		"Free shipping":    2,
+		"machine learning": 1,
+		"Our machine":      0,
+		"shipping today":   0,
+		"on all":           0,
+		"team does":        0,
+		"does machine":     0,
+		"state of":         0,
+	}
+	for k, v := range expected {
+		if wds.phrases.counts[k] != v {
+			t.Errorf("expected %d counts of '%s', got %d\n", v, k,
+				wds.phrases.counts[k])
+		}
+	}
+
+	// Single words are still counted as usual.
+	if wds.counts["shipping"] != 2 || wds.counts["machine"] != 2 {
+		t.Fatalf("unexpected word counts: %v\n", wds.counts)
+	}
+
+	*ngram = 3
+	wds = newWordCounts(0, false)
+	scanText("bill of rights", wds, 1)
+	if len(wds.phrases.counts) != 1 || wds.phrases.counts["bill of rights"] != 1 {
+		t.Fatalf("unexpected trigrams: %v\n", wds.phrases.counts)
+	}
+
+	// Hyphenated words run together when they are split.
+	defer func(t Tokenizer) { tokenizer = t }(tokenizer)
+	tokenizer = &unicodeTokenizer{}
+	*ngram = 4
+	wds = newWordCounts(0, false)
+	scanText("state-of-the-art", wds, 1)
+	if wds.phrases.counts["state of the art"] != 1 {
+		t.Fatalf("unexpected 4-grams: %v\n", wds.phrases.counts)
+	}
+}
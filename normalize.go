@@ -0,0 +1,75 @@
+// Word normalization merges the different spellings of a word into one
+// histogram key, so that "Parallelogram" and "parallelogram" (and, if
+// asked, "résumé" and "resume") are counted together.  Case folding is
+// Unicode-aware, so for example "STRASSE" and "straße" also merge.
+// Stemming goes further, merging the inflected forms of a word.
+package main
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// The combining diacritical mark blocks.  We don't remove all nonspacing
+// marks, as in scripts such as Devanagari they are essential vowels.
+var diacritics = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x0300, Hi: 0x036f, Stride: 1},
+		{Lo: 0x1ab0, Hi: 0x1aff, Stride: 1},
+		{Lo: 0x1dc0, Hi: 0x1dff, Stride: 1},
+		{Lo: 0x20d0, Hi: 0x20ff, Stride: 1},
+		{Lo: 0xfe20, Hi: 0xfe2f, Stride: 1},
+	},
+}
+
+// Casers and transformers keep state, so they can't be shared by the
+// workers.  Pools let each worker borrow its own.
+var (
+	folders = sync.Pool{New: func() any {
+		c := cases.Fold()
+		return &c
+	}}
+	accentStrippers = sync.Pool{New: func() any {
+		return transform.Chain(norm.NFD, runes.Remove(runes.In(diacritics)),
+			norm.NFC)
+	}}
+)
+
+// Reports whether any normalization is configured, in which case the
+// histogram keys may differ from the words as they appeared.
+func normalizing() bool {
+	return *foldCase || *stripAccents || *stemLang != ""
+}
+
+// Reports whether the surface forms of each key should be kept, so
+// that the report can show a word rather than a key.  Stems are often
+// not words, so the forms are always kept when stemming.
+func trackForms() bool {
+	return *stemLang != "" || (*surfaceForms && normalizing())
+}
+
+// Returns the histogram key for a word, which is already in NFC form.
+func normalizeWord(word string) string {
+	if *stripAccents {
+		t := accentStrippers.Get().(transform.Transformer)
+		if s, _, err := transform.String(t, word); err == nil {
+			word = s
+		}
+		accentStrippers.Put(t)
+	}
+	if *foldCase {
+		c := folders.Get().(*cases.Caser)
+		word = c.String(word)
+		folders.Put(c)
+	}
+	if stem := stemmers[*stemLang]; stem != nil {
+		word = stem(strings.ToLower(word))
+	}
+	return word
+}
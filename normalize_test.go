@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+// Test that words are folded and stripped as configured.
+func TestNormalizeWord(t *testing.T) {
+	defer func() { *foldCase, *stripAccents = false, false }()
+	tests := []struct {
+		fold, strip bool
+		word        string
+		expected    string
+	}{
+		{false, false, "Résumé", "Résumé"},
+		{true, false, "Résumé", "résumé"},
+		{false, true, "Résumé", "Resume"},
+		{true, true, "Résumé", "resume"},
+		{true, false, "STRASSE", "strasse"},
+		{true, false, "Straße", "strasse"},
+		{true, false, "ΣΊΣΥΦΟΣ", "σίσυφοσ"},
+
+		// Marks that are part of the letter, not accents, are kept.
+		{false, true, "हिन्दी", "हिन्दी"},
+	}
+	for _, tc := range tests {
+		*foldCase, *stripAccents = tc.fold, tc.strip
+		if got := normalizeWord(tc.word); got != tc.expected {
+			t.Errorf("fold %t strip %t: %q expected %q, got %q\n",
+				tc.fold, tc.strip, tc.word, tc.expected, got)
+		}
+	}
+}
+
+// Test that the most common surface form of a folded word is kept.
+func TestSurfaceForms(t *testing.T) {
+	defer func() { *foldCase, *surfaceForms = false, false }()
+	*foldCase, *surfaceForms = true, true
+	*minLen = 4
+	*maxLen = 0
+
+	wds := newWordCounts(0, false)
+	scanText("Paris paris Paris PARIS berlin Berlin", wds, 1)
+	other := newWordCounts(0, false)
+	scanText("Berlin", other, 1)
+	wds.merge(other)
+
+	if wds.counts["paris"] != 4 || wds.counts["berlin"] != 3 {
+		t.Fatalf("unexpected counts: %v\n", wds.counts)
+	}
+	if f := wds.form("paris"); f != "Paris" {
+		t.Fatalf("expected form Paris, got %q\n", f)
+	}
+	if f := wds.form("berlin"); f != "Berlin" {
+		t.Fatalf("expected form Berlin, got %q\n", f)
+	}
+}
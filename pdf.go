@@ -0,0 +1,959 @@
+// The PDF extractor gets the text of PDF documents, which sites often
+// link to for manuals and papers.  It is a small, forgiving reader, not
+// a full implementation of the format: it finds the objects by scanning
+// for them rather than trusting the cross-reference table, unpacks
+// object streams, decodes Flate-compressed streams, and runs the text
+// operators of each page's content streams.  Text is decoded with the
+// font's ToUnicode CMap if it has one, or else its single-byte encoding
+// (WinAnsi, MacRoman or Standard, with any Differences).  Encrypted
+// documents and text in form XObjects aren't supported.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// The types of PDF objects, besides numbers (float64), strings
+// ([]byte), booleans and null (nil).
+type (
+	pdfName  string
+	pdfRef   int // the number of the object referred to
+	pdfDict  map[pdfName]any
+	pdfArray []any
+	pdfOp    string // an operator in a content stream or CMap
+)
+
+// A pdfObject is an indirect object, along with its decoded stream
+// data if it has any we can decode.
+type pdfObject struct {
+	value  any
+	stream []byte
+}
+
+// A pdfDoc holds the objects of a document by number, and the number
+// of bytes its streams have decompressed to so far.
+type pdfDoc struct {
+	objects  map[int]*pdfObject
+	inflated int64
+}
+
+var (
+	pdfObjHeader    = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+	errPDFSyntax    = errors.New("PDF syntax error")
+	errPDFEncrypted = errors.New("encrypted PDF")
+)
+
+func extractPDF(ctx context.Context, sr searchRecord, body *bufio.Reader,
+	contentType string, wf *WordFinder) (*wordCounts, []string,
+	pageDirectives, error) {
+	data, err := io.ReadAll(io.LimitReader(body, *maxPDFBytes+1))
+	if err != nil {
+		// The document couldn't be fetched, rather than read.
+		return nil, nil, pageDirectives{}, err
+	}
+	if int64(len(data)) > *maxPDFBytes {
+		err = fmt.Errorf("larger than %d bytes", *maxPDFBytes)
+	}
+	var wds *wordCounts
+	if err == nil {
+		wds, err = pdfWords(ctx, data, wf)
+	}
+	wf.notePDF(err == nil)
+	if err != nil {
+		log.Printf("error extracting text of PDF '%s': %v\n", sr.url, err)
+		return nil, nil, pageDirectives{}, nil
+	}
+	return wds, nil, pageDirectives{}, nil
+}
+
+// Counts the words on the pages of a PDF document.  The text between
+// each BT and ET operator is scanned as one block.
+func pdfWords(ctx context.Context, data []byte,
+	wf *WordFinder) (*wordCounts, error) {
+	doc, err := parsePDF(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	pages := doc.pages()
+	if len(pages) == 0 {
+		return nil, errors.New("no pages found")
+	}
+	wds := newWordCounts(0, wf.weights != nil)
+	cache := make(map[pdfRef]*pdfFont)
+	for _, page := range pages {
+		if ctx.Err() != nil {
+			break
+		}
+		fonts := doc.fonts(doc.resources(page), cache)
+		content := bytes.Join(doc.contents(page["Contents"]), []byte("\n"))
+		showText(content, fonts, func(text string) {
+			scanText(text, wds, 1)
+		})
+	}
+	return wds, nil
+}
+
+// Finds the objects of a document, including those in object streams.
+// Where an object is defined more than once, as happens when a document
+// has been updated, the last definition wins.  Headers inside the span
+// of the object before them are skipped, even if that object was
+// malformed, so that each byte is lexed only once.
+func parsePDF(ctx context.Context, data []byte) (*pdfDoc, error) {
+	head := data[:min(len(data), 1024)]
+	if !bytes.Contains(head, []byte("%PDF-")) {
+		return nil, errors.New("not a PDF document")
+	}
+	doc := &pdfDoc{objects: make(map[int]*pdfObject)}
+	var objStreams []*pdfObject
+	cursor := 0
+	for _, m := range pdfObjHeader.FindAllSubmatchIndex(data, -1) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if m[0] < cursor {
+			continue
+		}
+		num, err := strconv.Atoi(string(data[m[2]:m[3]]))
+		if err != nil {
+			continue
+		}
+		lx := &pdfLexer{b: data, pos: m[1]}
+		v, err := lx.value()
+		cursor = lx.pos
+		if err != nil {
+			continue
+		}
+		obj := &pdfObject{value: v}
+		if d, ok := v.(pdfDict); ok {
+			// A cross-reference stream's dictionary is the trailer.
+			if d["Type"] == pdfName("XRef") && d["Encrypt"] != nil {
+				return nil, errPDFEncrypted
+			}
+			if raw := lx.streamData(d); raw != nil {
+				obj.stream = doc.decodeStream(d, raw)
+				cursor = lx.pos
+			}
+			if d["Type"] == pdfName("ObjStm") && obj.stream != nil {
+				objStreams = append(objStreams, obj)
+			}
+		}
+		doc.objects[num] = obj
+	}
+	for _, obj := range objStreams {
+		doc.unpack(obj.value.(pdfDict), obj.stream)
+	}
+	if encrypted(data) {
+		return nil, errPDFEncrypted
+	}
+	if len(doc.objects) == 0 {
+		return nil, errors.New("no objects found")
+	}
+	return doc, nil
+}
+
+// Reports whether any trailer dictionary of the document, of which an
+// updated document has several, has an /Encrypt entry.
+func encrypted(data []byte) bool {
+	for i := 0; ; {
+		t := bytes.Index(data[i:], []byte("trailer"))
+		if t < 0 {
+			return false
+		}
+		lx := &pdfLexer{b: data, pos: i + t + len("trailer")}
+		if v, err := lx.value(); err == nil {
+			if d, ok := v.(pdfDict); ok && d["Encrypt"] != nil {
+				return true
+			}
+		}
+		i = min(lx.pos, len(data))
+	}
+}
+
+// Adds the objects in an object stream, which starts with pairs of
+// object numbers and offsets.
+func (doc *pdfDoc) unpack(d pdfDict, data []byte) {
+	n, _ := d["N"].(float64)
+	first, _ := d["First"].(float64)
+	lx := &pdfLexer{b: data}
+	for i := 0; i < int(n); i++ {
+		num, err1 := lx.value()
+		off, err2 := lx.value()
+		if err1 != nil || err2 != nil {
+			return
+		}
+		nf, ok1 := num.(float64)
+		of, ok2 := off.(float64)
+		pos := int(first) + int(of)
+		if !ok1 || !ok2 || pos < 0 || pos >= len(data) {
+			return
+		}
+		if _, ok := doc.objects[int(nf)]; ok {
+			continue
+		}
+		ol := &pdfLexer{b: data, pos: pos}
+		if v, err := ol.value(); err == nil {
+			doc.objects[int(nf)] = &pdfObject{value: v}
+		}
+	}
+}
+
+// Decodes the data of a stream, returning nil if it uses a filter we
+// don't support.  The decompressed size of all of the document's
+// streams together is limited to the maximum PDF size, as a guard
+// against compression bombs.
+func (doc *pdfDoc) decodeStream(d pdfDict, data []byte) []byte {
+	var filters []any
+	switch f := d["Filter"].(type) {
+	case pdfName:
+		filters = []any{f}
+	case pdfArray:
+		filters = f
+	}
+	if len(filters) > 0 && d["DecodeParms"] != nil {
+		// Predictors are used for images and cross-reference
+		// streams, neither of which hold text.
+		return nil
+	}
+	for _, f := range filters {
+		switch f {
+		case pdfName("FlateDecode"), pdfName("Fl"):
+			left := *maxPDFBytes - doc.inflated
+			if left <= 0 {
+				return nil
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil
+			}
+			data, err = io.ReadAll(io.LimitReader(zr, left))
+			doc.inflated += int64(len(data))
+			if err != nil && len(data) == 0 {
+				return nil
+			}
+		default:
+			return nil
+		}
+	}
+	return data
+}
+
+// Follows references until it reaches a direct object.
+func (doc *pdfDoc) resolve(v any) any {
+	for i := 0; i < 32; i++ {
+		r, ok := v.(pdfRef)
+		if !ok {
+			return v
+		}
+		obj := doc.objects[int(r)]
+		if obj == nil {
+			return nil
+		}
+		v = obj.value
+	}
+	return nil
+}
+
+// Returns the dictionary a value is or refers to, or nil.
+func (doc *pdfDoc) dict(v any) pdfDict {
+	d, _ := doc.resolve(v).(pdfDict)
+	return d
+}
+
+// Returns the page objects, in object number order.
+func (doc *pdfDoc) pages() []pdfDict {
+	var nums []int
+	for num, obj := range doc.objects {
+		if d, ok := obj.value.(pdfDict); ok && d["Type"] == pdfName("Page") {
+			nums = append(nums, num)
+		}
+	}
+	sort.Ints(nums)
+	pages := make([]pdfDict, len(nums))
+	for i, num := range nums {
+		pages[i] = doc.objects[num].value.(pdfDict)
+	}
+	return pages
+}
+
+// Returns the resources of a page, which may be inherited from its
+// ancestors in the page tree.
+func (doc *pdfDoc) resources(page pdfDict) pdfDict {
+	for d, i := page, 0; d != nil && i < 32; d, i = doc.dict(d["Parent"]), i+1 {
+		if r := doc.dict(d["Resources"]); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// Returns the decoded content streams of a page, which are given as a
+// stream or an array of them.
+func (doc *pdfDoc) contents(v any) [][]byte {
+	if r, ok := v.(pdfRef); ok {
+		obj := doc.objects[int(r)]
+		if obj == nil {
+			return nil
+		}
+		if obj.stream != nil {
+			return [][]byte{obj.stream}
+		}
+		v = obj.value
+	}
+	var res [][]byte
+	a, _ := v.(pdfArray)
+	for _, e := range a {
+		if r, ok := e.(pdfRef); ok {
+			if obj := doc.objects[int(r)]; obj != nil && obj.stream != nil {
+				res = append(res, obj.stream)
+			}
+		}
+	}
+	return res
+}
+
+// A pdfFont decodes the strings shown in a font to text.
+type pdfFont struct {
+	codeBytes int               // bytes per character code
+	cmap      map[uint32]string // from the ToUnicode CMap
+	enc       *charmap.Charmap  // for single-byte codes
+	diffs     map[byte]string   // overrides of the encoding
+}
+
+// The font used when a content stream names one we can't find.
+var defaultPDFFont = &pdfFont{codeBytes: 1, enc: charmap.Windows1252}
+
+// Returns the fonts in a page's resources by name, reusing those in
+// the cache, as pages usually share their fonts.
+func (doc *pdfDoc) fonts(res pdfDict,
+	cache map[pdfRef]*pdfFont) map[pdfName]*pdfFont {
+	fonts := make(map[pdfName]*pdfFont)
+	for name, v := range doc.dict(res["Font"]) {
+		r, isRef := v.(pdfRef)
+		if f := cache[r]; isRef && f != nil {
+			fonts[name] = f
+			continue
+		}
+		f := doc.font(doc.dict(v))
+		if isRef {
+			cache[r] = f
+		}
+		fonts[name] = f
+	}
+	return fonts
+}
+
+func (doc *pdfDoc) font(d pdfDict) *pdfFont {
+	f := &pdfFont{codeBytes: 1, enc: charmap.Windows1252}
+	if d["Subtype"] == pdfName("Type0") {
+		// Composite fonts have two byte codes by default, which
+		// only a CMap can tell us the meaning of.
+		f.codeBytes = 2
+		f.enc = nil
+	}
+	switch e := doc.resolve(d["Encoding"]).(type) {
+	case pdfName:
+		f.setEncoding(e)
+	case pdfDict:
+		if b, ok := e["BaseEncoding"].(pdfName); ok {
+			f.setEncoding(b)
+		}
+		diffs, _ := doc.resolve(e["Differences"]).(pdfArray)
+		f.setDifferences(diffs)
+	}
+	if r, ok := d["ToUnicode"].(pdfRef); ok {
+		if obj := doc.objects[int(r)]; obj != nil && obj.stream != nil {
+			f.parseCMap(obj.stream)
+		}
+	}
+	return f
+}
+
+func (f *pdfFont) setEncoding(name pdfName) {
+	switch name {
+	case "WinAnsiEncoding", "StandardEncoding":
+		f.enc = charmap.Windows1252
+	case "MacRomanEncoding":
+		f.enc = charmap.Macintosh
+	}
+}
+
+// Applies a Differences array, which gives glyph names for codes: a
+// number sets the next code, and each name is for the code after the
+// last.
+func (f *pdfFont) setDifferences(diffs pdfArray) {
+	code := 0
+	for _, v := range diffs {
+		switch v := v.(type) {
+		case float64:
+			code = int(v)
+		case pdfName:
+			if s := glyphText(string(v)); s != "" && code >= 0 && code < 256 {
+				if f.diffs == nil {
+					f.diffs = make(map[byte]string)
+				}
+				f.diffs[byte(code)] = s
+			}
+			code++
+		}
+	}
+}
+
+// The text of the commonly used glyph names that aren't a single
+// letter or of the form "uniXXXX".
+var glyphNames = map[string]string{
+	"space": " ", "hyphen": "-", "period": ".", "comma": ",",
+	"quoteright": "’", "quoteleft": "‘", "quotesingle": "'",
+	"quotedblleft": "“", "quotedblright": "”", "endash": "–",
+	"emdash": "—", "fi": "fi", "fl": "fl", "ff": "ff", "ffi": "ffi",
+	"ffl": "ffl", "zero": "0", "one": "1", "two": "2", "three": "3",
+	"four": "4", "five": "5", "six": "6", "seven": "7", "eight": "8",
+	"nine": "9", "eacute": "é", "egrave": "è", "agrave": "à",
+	"ccedilla": "ç", "udieresis": "ü", "odieresis": "ö",
+	"adieresis": "ä", "germandbls": "ß",
+}
+
+// Returns the text of a glyph name, or "" if we don't know it.
+func glyphText(name string) string {
+	if len(name) == 1 {
+		return name
+	}
+	if strings.HasPrefix(name, "uni") && len(name) == 7 {
+		if v, err := strconv.ParseUint(name[3:], 16, 16); err == nil {
+			return string(rune(v))
+		}
+	}
+	return glyphNames[name]
+}
+
+// Reads the mappings of a ToUnicode CMap, which gives the text of each
+// character code as UTF-16.
+func (f *pdfFont) parseCMap(data []byte) {
+	f.cmap = make(map[uint32]string)
+	lx := &pdfLexer{b: data}
+	var operands []any
+	for {
+		v, err := lx.value()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		op, isOp := v.(pdfOp)
+		if !isOp {
+			operands = append(operands, v)
+			continue
+		}
+		switch op {
+		case "endcodespacerange":
+			if len(operands) > 0 {
+				if s, ok := operands[0].([]byte); ok && len(s) > 0 &&
+					len(s) <= 4 {
+					f.codeBytes = len(s)
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].([]byte)
+				dst, ok2 := operands[i+1].([]byte)
+				if ok1 && ok2 {
+					f.cmap[pdfCode(src)] = utf16Text(dst)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].([]byte)
+				hi, ok2 := operands[i+1].([]byte)
+				if !ok1 || !ok2 {
+					continue
+				}
+				f.mapRange(pdfCode(lo), pdfCode(hi), operands[i+2])
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+// Maps a range of codes to consecutive characters starting with dst,
+// or to the strings in a dst array.
+func (f *pdfFont) mapRange(lo, hi uint32, dst any) {
+	if hi < lo || hi-lo > 0xffff {
+		return
+	}
+	switch dst := dst.(type) {
+	case []byte:
+		units := utf16Units(dst)
+		if len(units) == 0 {
+			return
+		}
+		for c := lo; c <= hi; c++ {
+			f.cmap[c] = string(utf16.Decode(units))
+			units[len(units)-1]++
+		}
+	case pdfArray:
+		for i, e := range dst {
+			if s, ok := e.([]byte); ok && lo+uint32(i) <= hi {
+				f.cmap[lo+uint32(i)] = utf16Text(s)
+			}
+		}
+	}
+}
+
+// Returns a character code from its big-endian bytes.
+func pdfCode(b []byte) uint32 {
+	var c uint32
+	for _, v := range b {
+		c = c<<8 | uint32(v)
+	}
+	return c
+}
+
+func utf16Units(b []byte) []uint16 {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+	return units
+}
+
+func utf16Text(b []byte) string {
+	return string(utf16.Decode(utf16Units(b)))
+}
+
+// Decodes a string shown in the font.
+func (f *pdfFont) decode(s []byte) string {
+	var sb strings.Builder
+	for i := 0; i+f.codeBytes <= len(s); i += f.codeBytes {
+		code := pdfCode(s[i : i+f.codeBytes])
+		if t, ok := f.cmap[code]; ok {
+			sb.WriteString(t)
+		} else if f.codeBytes == 1 {
+			if t, ok := f.diffs[byte(code)]; ok {
+				sb.WriteString(t)
+			} else if f.enc != nil {
+				sb.WriteRune(f.enc.DecodeByte(byte(code)))
+			}
+		}
+	}
+	return sb.String()
+}
+
+// Runs the text operators of a content stream, passing the text of
+// each text object to block.  Positioning operators separate words,
+// as do large gaps in a TJ array; they usually mean a new line or
+// column.
+func showText(content []byte, fonts map[pdfName]*pdfFont,
+	block func(string)) {
+	lx := &pdfLexer{b: content}
+	font := defaultPDFFont
+	var text strings.Builder
+	var operands []any
+	show := func(v any) {
+		if s, ok := v.([]byte); ok {
+			text.WriteString(font.decode(s))
+		}
+	}
+	flush := func() {
+		if text.Len() > 0 {
+			block(text.String())
+			text.Reset()
+		}
+	}
+	for {
+		v, err := lx.value()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		op, isOp := v.(pdfOp)
+		if !isOp {
+			operands = append(operands, v)
+			continue
+		}
+		var last any
+		if len(operands) > 0 {
+			last = operands[len(operands)-1]
+		}
+		switch op {
+		case "BT", "ET":
+			flush()
+		case "Tf":
+			if len(operands) >= 2 {
+				name, _ := operands[len(operands)-2].(pdfName)
+				if font = fonts[name]; font == nil {
+					font = defaultPDFFont
+				}
+			}
+		case "Tj":
+			show(last)
+		case "'", "\"":
+			text.WriteByte('\n')
+			show(last)
+		case "TJ":
+			a, _ := last.(pdfArray)
+			for _, e := range a {
+				if n, ok := e.(float64); ok && n < -200 {
+					text.WriteByte(' ')
+				} else {
+					show(e)
+				}
+			}
+		case "Td", "TD", "Tm", "T*":
+			text.WriteByte('\n')
+		case "BI":
+			lx.skipInlineImage()
+		}
+		operands = operands[:0]
+	}
+	flush()
+}
+
+// The pdfLexer reads PDF objects, and in content streams, operators.
+type pdfLexer struct {
+	b   []byte
+	pos int
+}
+
+func isPDFSpace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+func isPDFDelim(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// Skips white space and comments.
+func (lx *pdfLexer) skipSpace() {
+	for lx.pos < len(lx.b) {
+		c := lx.b[lx.pos]
+		if c == '%' {
+			for lx.pos < len(lx.b) && lx.b[lx.pos] != '\n' &&
+				lx.b[lx.pos] != '\r' {
+				lx.pos++
+			}
+			continue
+		}
+		if !isPDFSpace(c) {
+			return
+		}
+		lx.pos++
+	}
+}
+
+// The deepest that arrays and dictionaries may be nested in each other.
+// Real documents rarely go past a handful of levels, and the limit
+// keeps a hostile one from overflowing the stack.
+const maxPDFNesting = 64
+
+// Reads the next object or operator.  It returns io.EOF at the end of
+// the data, and errPDFSyntax, having skipped the offending character,
+// when it finds one that can't start an object or objects nested too
+// deeply.
+func (lx *pdfLexer) value() (any, error) {
+	return lx.nested(0)
+}
+
+// Reads the next object, which is inside depth arrays or dictionaries.
+func (lx *pdfLexer) nested(depth int) (any, error) {
+	lx.skipSpace()
+	if lx.pos >= len(lx.b) {
+		return nil, io.EOF
+	}
+	c := lx.b[lx.pos]
+	switch {
+	case c == '/':
+		return lx.name(), nil
+	case c == '(':
+		return lx.literal(), nil
+	case c == '<' && lx.pos+1 < len(lx.b) && lx.b[lx.pos+1] == '<':
+		return lx.dict(depth + 1)
+	case c == '<':
+		return lx.hex(), nil
+	case c == '[':
+		return lx.array(depth + 1)
+	case c == '+' || c == '-' || c == '.' || isDigit(c):
+		return lx.number()
+	case isPDFDelim(c):
+		lx.pos++
+		return nil, errPDFSyntax
+	}
+	return lx.keyword(), nil
+}
+
+func (lx *pdfLexer) name() pdfName {
+	lx.pos++
+	var name []byte
+	for lx.pos < len(lx.b) && !isPDFSpace(lx.b[lx.pos]) &&
+		!isPDFDelim(lx.b[lx.pos]) {
+		c := lx.b[lx.pos]
+		if c == '#' && lx.pos+2 < len(lx.b) {
+			if v, err := strconv.ParseUint(string(lx.b[lx.pos+1:lx.pos+3]),
+				16, 8); err == nil {
+				name = append(name, byte(v))
+				lx.pos += 3
+				continue
+			}
+		}
+		name = append(name, c)
+		lx.pos++
+	}
+	return pdfName(name)
+}
+
+// Reads a literal string, which may contain balanced parentheses and
+// backslash escapes.
+func (lx *pdfLexer) literal() []byte {
+	lx.pos++
+	var s []byte
+	depth := 1
+	for lx.pos < len(lx.b) {
+		c := lx.b[lx.pos]
+		lx.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return s
+			}
+		case '\\':
+			if lx.pos >= len(lx.b) {
+				return s
+			}
+			c = lx.b[lx.pos]
+			lx.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// A line continuation.
+				if lx.pos < len(lx.b) && lx.b[lx.pos] == '\n' {
+					lx.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					v := c - '0'
+					for i := 0; i < 2 && lx.pos < len(lx.b) &&
+						lx.b[lx.pos] >= '0' && lx.b[lx.pos] <= '7'; i++ {
+						v = v<<3 | (lx.b[lx.pos] - '0')
+						lx.pos++
+					}
+					c = v
+				}
+			}
+		}
+		s = append(s, c)
+	}
+	return s
+}
+
+func (lx *pdfLexer) hex() []byte {
+	lx.pos++
+	var digits []byte
+	for lx.pos < len(lx.b) && lx.b[lx.pos] != '>' {
+		c := lx.b[lx.pos]
+		lx.pos++
+		if isDigit(c) || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F') {
+			digits = append(digits, c)
+		}
+	}
+	if lx.pos < len(lx.b) {
+		lx.pos++ // the closing '>'
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	s := make([]byte, len(digits)/2)
+	for i := range s {
+		v, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		s[i] = byte(v)
+	}
+	return s
+}
+
+func (lx *pdfLexer) dict(depth int) (pdfDict, error) {
+	lx.pos += 2
+	if depth > maxPDFNesting {
+		return nil, errPDFSyntax
+	}
+	d := make(pdfDict)
+	for {
+		lx.skipSpace()
+		if lx.pos+1 < len(lx.b) && lx.b[lx.pos] == '>' &&
+			lx.b[lx.pos+1] == '>' {
+			lx.pos += 2
+			return d, nil
+		}
+		k, err := lx.nested(depth)
+		if err != nil {
+			return d, err
+		}
+		key, ok := k.(pdfName)
+		if !ok {
+			return d, errPDFSyntax
+		}
+		v, err := lx.nested(depth)
+		if err != nil {
+			return d, err
+		}
+		d[key] = v
+	}
+}
+
+func (lx *pdfLexer) array(depth int) (pdfArray, error) {
+	lx.pos++
+	if depth > maxPDFNesting {
+		return nil, errPDFSyntax
+	}
+	var a pdfArray
+	for {
+		lx.skipSpace()
+		if lx.pos < len(lx.b) && lx.b[lx.pos] == ']' {
+			lx.pos++
+			return a, nil
+		}
+		v, err := lx.nested(depth)
+		if err != nil {
+			return a, err
+		}
+		a = append(a, v)
+	}
+}
+
+// Reads a number, or a reference, which is an object number followed
+// by a generation number and 'R'.
+func (lx *pdfLexer) number() (any, error) {
+	start := lx.pos
+	for lx.pos < len(lx.b) && strings.IndexByte("+-.0123456789",
+		lx.b[lx.pos]) >= 0 {
+		lx.pos++
+	}
+	text := string(lx.b[start:lx.pos])
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, errPDFSyntax
+	}
+	if strings.IndexAny(text, "+-.") == -1 {
+		save := lx.pos
+		lx.skipSpace()
+		gen := lx.pos
+		for lx.pos < len(lx.b) && isDigit(lx.b[lx.pos]) {
+			lx.pos++
+		}
+		if lx.pos > gen {
+			lx.skipSpace()
+			if lx.pos < len(lx.b) && lx.b[lx.pos] == 'R' &&
+				(lx.pos+1 == len(lx.b) || isPDFSpace(lx.b[lx.pos+1]) ||
+					isPDFDelim(lx.b[lx.pos+1])) {
+				lx.pos++
+				return pdfRef(int(f)), nil
+			}
+		}
+		lx.pos = save
+	}
+	return f, nil
+}
+
+func (lx *pdfLexer) keyword() any {
+	start := lx.pos
+	for lx.pos < len(lx.b) && !isPDFSpace(lx.b[lx.pos]) &&
+		!isPDFDelim(lx.b[lx.pos]) {
+		lx.pos++
+	}
+	switch s := string(lx.b[start:lx.pos]); s {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	default:
+		return pdfOp(s)
+	}
+}
+
+// Returns the data of the stream following a stream dictionary, or
+// nil if there isn't one, leaving the lexer at the end of the data.
+// The stream's length is used if it is given directly and is right,
+// and otherwise we look for its end.
+func (lx *pdfLexer) streamData(d pdfDict) []byte {
+	lx.skipSpace()
+	if !bytes.HasPrefix(lx.b[lx.pos:], []byte("stream")) {
+		return nil
+	}
+	start := lx.pos + len("stream")
+	if start < len(lx.b) && lx.b[start] == '\r' {
+		start++
+	}
+	if start < len(lx.b) && lx.b[start] == '\n' {
+		start++
+	}
+	// A length past the end of the data, however large, is wrong.
+	if n, ok := d["Length"].(float64); ok && n >= 0 &&
+		n <= float64(len(lx.b)-start) {
+		if end := start + int(n); bytes.HasPrefix(
+			bytes.TrimLeft(lx.b[end:min(end+16, len(lx.b))], "\r\n "),
+			[]byte("endstream")) {
+			lx.pos = end
+			return lx.b[start:end]
+		}
+	}
+	end := bytes.Index(lx.b[start:], []byte("endstream"))
+	if end < 0 {
+		return nil
+	}
+	lx.pos = start + end
+	return bytes.TrimRight(lx.b[start:start+end], "\r\n")
+}
+
+// Skips the data of an inline image, up to its EI operator.
+func (lx *pdfLexer) skipInlineImage() {
+	id := bytes.Index(lx.b[lx.pos:], []byte("ID"))
+	if id < 0 {
+		lx.pos = len(lx.b)
+		return
+	}
+	for i := lx.pos + id + 2; i+2 <= len(lx.b); i++ {
+		if lx.b[i] == 'E' && lx.b[i+1] == 'I' && isPDFSpace(lx.b[i-1]) &&
+			(i+2 == len(lx.b) || isPDFSpace(lx.b[i+2])) {
+			lx.pos = i + 2
+			return
+		}
+	}
+	lx.pos = len(lx.b)
+}
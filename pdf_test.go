@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Builds a PDF document from the bodies of its objects, numbered from 1.
+// A body with a "stream" key holds a dictionary and stream data.
+func buildPDF(objects []any) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	for i, o := range objects {
+		fmt.Fprintf(&b, "%d 0 obj\n", i+1)
+		switch o := o.(type) {
+		case string:
+			b.WriteString(o)
+		case [2]string:
+			fmt.Fprintf(&b, "%s\nstream\n%s\nendstream", o[0], o[1])
+		}
+		b.WriteString("\nendobj\n")
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return b.Bytes()
+}
+
+func deflate(s string) string {
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	zw.Write([]byte(s))
+	zw.Close()
+	return b.String()
+}
+
+// Test the text extracted from a document using compressed content,
+// an object stream, and both simple and composite fonts.
+func TestPDFText(t *testing.T) {
+	defer func() { *pdfText = false }()
+	*pdfText = true
+	*minLen = 4
+	*maxLen = 0
+
+	content1 := deflate(`BT /F1 12 Tf 72 720 Td (Hello ) Tj
+		[(Docu) -20 (mentation) -400 (spaced)] TJ
+		0 -14 Td (caf\001 line\\) Tj T* (paren\)thesis) Tj ET
+		BI /W 4 /H 1 /BPC 8 /CS /G ID (notword) Tj
+		EI`)
+	content2 := `BT /F2 10 Tf <001500100012001000130014> Tj ET
+		BT /Missing 9 Tf (fallback) ' ET`
+	cmap := `/CIDInit /ProcSet findresource begin 12 dict begin begincmap
+		1 begincodespacerange <0000> <FFFF> endcodespacerange
+		1 beginbfrange <0010> <0015> <0041> endbfrange
+		1 beginbfchar <0020> <00E9> endbfchar
+		endcmap CMapName currentdict /CMap defineresource pop end end`
+	objstm := "9 0 << /Type /Font /Subtype /Type1 /BaseFont /Helvetica " +
+		"/Encoding << /BaseEncoding /WinAnsiEncoding /Differences [1 /eacute] >> >>"
+
+	doc := buildPDF([]any{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 " +
+			"/Resources << /Font << /F1 9 0 R /F2 6 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents [4 0 R 8 0 R] >>",
+		[2]string{fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>",
+			len(content1)), content1},
+		[2]string{"<< /Type /ObjStm /N 1 /First 4 /Filter /FlateDecode >>",
+			deflate(objstm)},
+		"<< /Type /Font /Subtype /Type0 /Encoding /Identity-H /ToUnicode 7 0 R >>",
+		[2]string{"<< /Length 9999 >>", cmap},
+		[2]string{fmt.Sprintf("<< /Length %d >>", len(content2)), content2},
+	})
+
+	wf := testFinder(t)
+	sr := searchRecord{url: "http://example.com/doc.pdf"}
+	ext, _ := wf.extractorFor("application/pdf")
+	wds, _, _, _ := ext(context.Background(), sr,
+		bufio.NewReader(bytes.NewReader(doc)), "application/pdf", wf)
+	var got []string
+	for k := range wds.counts {
+		got = append(got, k)
+	}
+	sort.Strings(got)
+	expected := "Documentation FACADE Hello café fallback line paren spaced thesis"
+	if strings.Join(got, " ") != expected {
+		t.Fatalf("expected %q, got %q\n", expected, strings.Join(got, " "))
+	}
+
+	// Documents that are too large, or not PDFs, are counted as failed.
+	defer func(n int64) { *maxPDFBytes = n }(*maxPDFBytes)
+	*maxPDFBytes = int64(len(doc) - 1)
+	for _, bad := range [][]byte{doc, []byte("<html>not a pdf</html>")} {
+		wds, _, _, _ = extractPDF(context.Background(), sr,
+			bufio.NewReader(bytes.NewReader(bad)), "application/pdf", wf)
+		if !wds.empty() {
+			t.Errorf("expected no words from bad PDF\n")
+		}
+	}
+	if st := wf.getStats(); st.pdfs != 1 || st.pdfFailed != 2 {
+		t.Fatalf("expected 1 processed and 2 failed, got %d and %d\n",
+			st.pdfs, st.pdfFailed)
+	}
+
+	// PDFs are skipped unless extraction is on.
+	*pdfText = false
+	if ext, known := testFinder(t).extractorFor("application/pdf"); ext != nil ||
+		!known {
+		t.Fatalf("expected PDFs to be skipped by default\n")
+	}
+}
+
+// Test that hostile documents are handled: stream lengths past the end
+// of the data, many streams that together inflate past the limit, and
+// that only a trailer's /Encrypt marks a document as encrypted.
+func TestPDFLimits(t *testing.T) {
+	defer func(n int64) { *maxPDFBytes = n }(*maxPDFBytes)
+	content := "BT /F1 12 Tf (Readable) Tj ET"
+	page := func(contents string) []any {
+		return []any{
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+			"<< /Type /Page /Parent 2 0 R /Contents " + contents + " >>",
+		}
+	}
+	for _, length := range []string{"9223372036854775807", "100000000000000000000",
+		"99999"} {
+		objs := append(page("4 0 R"), [2]string{"<< /Length " + length +
+			" >>", content})
+		doc, err := parsePDF(context.Background(), buildPDF(objs))
+		if err != nil {
+			t.Fatalf("length %s: parse failed: %v\n", length, err)
+		}
+		if string(doc.objects[4].stream) != content {
+			t.Errorf("length %s: unexpected stream %q\n", length,
+				doc.objects[4].stream)
+		}
+	}
+
+	// Four streams of 1000 bytes each share a 2500 byte limit.
+	*maxPDFBytes = 2500
+	big := deflate(strings.Repeat("x", 1000))
+	objs := page("[4 0 R 5 0 R 6 0 R 7 0 R]")
+	for i := 0; i < 4; i++ {
+		objs = append(objs, [2]string{"<< /Filter /FlateDecode >>", big})
+	}
+	doc, err := parsePDF(context.Background(), buildPDF(objs))
+	if err != nil {
+		t.Fatalf("parse failed: %v\n", err)
+	}
+	total := 0
+	for n := 4; n <= 7; n++ {
+		total += len(doc.objects[n].stream)
+	}
+	if total != 2500 {
+		t.Errorf("expected 2500 bytes inflated, got %d\n", total)
+	}
+
+	// Content that mentions /Encrypt isn't encryption.
+	objs = append(page("4 0 R"), [2]string{"<< >>",
+		"BT (/Encrypt) Tj ET"})
+	if _, err := parsePDF(context.Background(), buildPDF(objs)); err != nil {
+		t.Errorf("expected plain PDF, got %v\n", err)
+	}
+	encrypted := bytes.Replace(buildPDF(page("[]")), []byte("/Root 1 0 R"),
+		[]byte("/Root 1 0 R /Encrypt 9 0 R"), 1)
+	if _, err := parsePDF(context.Background(), encrypted); err != errPDFEncrypted {
+		t.Errorf("expected encrypted error, got %v\n", err)
+	}
+}
+
+// Test that deeply nested and unterminated objects fail quickly rather
+// than overflowing the stack or being lexed again from every header.
+func TestPDFMalformed(t *testing.T) {
+	deep := "%PDF-1.7\n1 0 obj\n" + strings.Repeat("[", 8<<20)
+	if _, err := parsePDF(context.Background(), []byte(deep)); err == nil {
+		t.Errorf("expected deeply nested arrays to fail\n")
+	}
+	lx := &pdfLexer{b: []byte(strings.Repeat("<< /K ", 100))}
+	if _, err := lx.value(); err != errPDFSyntax {
+		t.Errorf("expected syntax error for nested dictionaries, got %v\n",
+			err)
+	}
+	lx = &pdfLexer{b: []byte(strings.Repeat("[", 64) + strings.Repeat("]", 64))}
+	if _, err := lx.value(); err != nil {
+		t.Errorf("expected 64 nested arrays to parse, got %v\n", err)
+	}
+
+	unterminated := "%PDF-1.7\n" + strings.Repeat("1 0 obj [ (", 100000)
+	if _, err := parsePDF(context.Background(), []byte(unterminated)); err == nil {
+		t.Errorf("expected unterminated objects to fail\n")
+	}
+	truncated := "%PDF-1.4\ntrailer <ab"
+	if _, err := parsePDF(context.Background(), []byte(truncated)); err == nil {
+		t.Errorf("expected truncated trailer to fail\n")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := parsePDF(ctx, buildPDF([]any{"<< >>"})); err != context.Canceled {
+		t.Errorf("expected canceled error, got %v\n", err)
+	}
+}
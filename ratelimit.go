@@ -0,0 +1,169 @@
+// The rate limiter keeps the worker goroutines from hammering a host.
+// Each host gets its own token bucket, and on top of that we honor
+// any robots.txt Crawl-delay and back a host off when it tells us
+// it is overloaded with a 429 or 503 response.
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Back-off bounds for overloaded hosts.  A host that doesn't send
+	// Retry-After starts at the minimum, and one that does is held no
+	// longer than the maximum, whatever it asks for.
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// The hostLimiter hands out permission to make requests, per host.
+// It is safe for concurrent use by the workers.
+type hostLimiter struct {
+	rate  float64 // requests per second, 0 => no limit
+	burst int
+	mu    sync.Mutex
+	hosts map[string]*hostBucket
+}
+
+// The state of a single host.  Tokens may go negative, which means
+// that future time slots have already been reserved by waiting workers.
+type hostBucket struct {
+	tokens  float64
+	last    time.Time
+	next    time.Time     // earliest start allowed by the Crawl-delay
+	until   time.Time     // the host is backed off until this time
+	backoff time.Duration // current back-off, doubled on each overload
+}
+
+// Creates a new limiter allowing rate requests per second per host,
+// with bursts of up to burst requests.
+func newHostLimiter(rate float64, burst int) *hostLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &hostLimiter{
+		rate:  rate,
+		burst: burst,
+		hosts: make(map[string]*hostBucket),
+	}
+}
+
+// Blocks until a request to the host may be made, or until the context
+// is done.  The crawl delay, if non-zero, is the minimum spacing between
+// requests to the host.  Returns how long we had to wait.
+func (hl *hostLimiter) wait(ctx context.Context, host string,
+	crawlDelay time.Duration) (time.Duration, error) {
+	now := time.Now()
+	start := now
+
+	hl.mu.Lock()
+	b := hl.bucket(host, now)
+	if hl.rate > 0 {
+		// Reserve a token, waiting for one to accrue if need be.
+		b.tokens += hl.rate * now.Sub(b.last).Seconds()
+		if b.tokens > float64(hl.burst) {
+			b.tokens = float64(hl.burst)
+		}
+		b.last = now
+		b.tokens--
+		if b.tokens < 0 {
+			start = now.Add(time.Duration(-b.tokens / hl.rate *
+				float64(time.Second)))
+		}
+	}
+	if b.until.After(start) {
+		start = b.until
+	}
+	if crawlDelay > 0 {
+		if b.next.After(start) {
+			start = b.next
+		}
+		b.next = start.Add(crawlDelay)
+	}
+	hl.mu.Unlock()
+
+	d := start.Sub(now)
+	if d <= 0 {
+		return 0, nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return time.Since(now), ctx.Err()
+	case <-t.C:
+		return d, nil
+	}
+}
+
+// Backs the host off after it reported being overloaded.  If the host
+// sent a Retry-After we use that, up to maxBackoff, otherwise we double
+// the previous back-off, within limits.
+func (hl *hostLimiter) overloaded(host string, retryAfter time.Duration) {
+	now := time.Now()
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+	b := hl.bucket(host, now)
+	if retryAfter > 0 {
+		b.backoff = min(retryAfter, maxBackoff)
+	} else {
+		b.backoff *= 2
+		if b.backoff < minBackoff {
+			b.backoff = minBackoff
+		} else if b.backoff > maxBackoff {
+			b.backoff = maxBackoff
+		}
+	}
+	if until := now.Add(b.backoff); until.After(b.until) {
+		b.until = until
+	}
+}
+
+// Resets the host's back-off after a successful request.
+func (hl *hostLimiter) succeeded(host string) {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+	if b := hl.hosts[host]; b != nil {
+		b.backoff = 0
+	}
+}
+
+// Returns the bucket for the host, creating a full one if need be.
+// The caller must hold the lock.
+func (hl *hostLimiter) bucket(host string, now time.Time) *hostBucket {
+	b := hl.hosts[host]
+	if b == nil {
+		b = &hostBucket{tokens: float64(hl.burst), last: now}
+		hl.hosts[host] = b
+	}
+	return b
+}
+
+// Parses a Retry-After header, which is either a number of seconds
+// or an HTTP date.  Returns zero if absent or invalid.
+func parseRetryAfter(v string) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		// Any longer would overflow, and overloaded clamps it anyway.
+		secs = min(secs, int(math.MaxInt64/time.Second))
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that requests beyond the burst are spaced out by the rate,
+// and that hosts are limited independently.
+func TestHostLimiter(t *testing.T) {
+	ctx := context.Background()
+	hl := newHostLimiter(20, 2)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := hl.wait(ctx, "a.example.com", 0); err != nil {
+			t.Fatalf("unexpected error: %v\n", err)
+		}
+	}
+
+	// Two immediate requests, then two more at 50ms intervals.
+	if el := time.Since(start); el < 90*time.Millisecond {
+		t.Fatalf("requests were not rate limited: %v\n", el)
+	}
+
+	d, err := hl.wait(ctx, "b.example.com", 0)
+	if err != nil || d != 0 {
+		t.Fatalf("other host should not wait: %v, %v\n", d, err)
+	}
+
+	// A crawl delay spaces requests even without a rate.
+	hl = newHostLimiter(0, 1)
+	hl.wait(ctx, "c.example.com", 50*time.Millisecond)
+	d, _ = hl.wait(ctx, "c.example.com", 50*time.Millisecond)
+	if d < 40*time.Millisecond {
+		t.Fatalf("crawl delay was not honored: %v\n", d)
+	}
+
+	// A cancelled context stops the wait.
+	hl.overloaded("d.example.com", time.Hour)
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := hl.wait(cctx, "d.example.com", 0); err == nil {
+		t.Fatalf("expected cancellation error\n")
+	}
+
+	// A huge Retry-After holds the host for no more than maxBackoff.
+	hl.overloaded("e.example.com", parseRetryAfter("99999999999999"))
+	if d := time.Until(hl.hosts["e.example.com"].until); d <= 0 ||
+		d > maxBackoff {
+		t.Fatalf("Retry-After was not clamped: %v\n", d)
+	}
+}
+
+// Test both forms of the Retry-After header.
+func TestParseRetryAfter(t *testing.T) {
+	if d := parseRetryAfter("120"); d != 2*time.Minute {
+		t.Fatalf("unexpected delay: %v\n", d)
+	}
+	for _, v := range []string{"", "-5", "soon"} {
+		if d := parseRetryAfter(v); d != 0 {
+			t.Fatalf("expected no delay for %q, got %v\n", v, d)
+		}
+	}
+	when := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if d := parseRetryAfter(when); d < 59*time.Minute || d > time.Hour {
+		t.Fatalf("unexpected delay for date: %v\n", d)
+	}
+}
+
+// Returns a transport that connects to the test server at addr for
+// every host but 127.0.0.1, so that tests can use many host names.
+func hostsTransport(addr string) *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network,
+			hostport string) (net.Conn, error) {
+			if host, _, _ := net.SplitHostPort(hostport); host != "127.0.0.1" {
+				hostport = addr
+			}
+			var d net.Dialer
+			return d.DialContext(ctx, network, hostport)
+		},
+	}
+}
+
+// Test that each hop of a redirect is paced, and that an overloaded
+// response backs off the host we asked for, not the one redirected to.
+func TestRedirectOverload(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		switch {
+		case r.URL.Path == "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/moved">moved</a>`))
+		case r.URL.Path == "/moved":
+			http.Redirect(w, r, "http://www."+r.Host+"/final",
+				http.StatusFound)
+		case r.URL.Path == "/final":
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	addr := strings.TrimPrefix(ts.URL, "http://")
+	_, port, _ := net.SplitHostPort(addr)
+	u, err := url.Parse("http://site.test:" + port + "/")
+	if err != nil {
+		t.Fatalf("URL parse failed: %v\n", err)
+	}
+	defer func(n int) { *retries = n }(*retries)
+	*retries = 0
+	finder, err := newWordFinder([]*url.URL{u}, newFormatter())
+	if err != nil {
+		t.Fatalf("creating finder failed: %v\n", err)
+	}
+	finder.client.Transport = hostsTransport(addr)
+	finder.robots.client.Transport = finder.client.Transport
+	finder.run(context.Background())
+
+	hosts := finder.limiter.hosts
+	site, www := hosts["site.test:"+port], hosts["www.site.test:"+port]
+	if www == nil {
+		t.Fatalf("redirect to another host was not paced\n")
+	}
+	if site == nil || time.Until(site.until) < 30*time.Second ||
+		!www.until.IsZero() {
+		t.Fatalf("backed off the wrong host\n")
+	}
+}
@@ -0,0 +1,313 @@
+// The robots code fetches and interprets the robots.txt file for each
+// host we crawl, so that we stay out of the parts of a site its owners
+// have asked crawlers to avoid.  The matching rules follow RFC 9309:
+// the most specific (longest) matching rule wins, "Allow" wins a tie,
+// and patterns may use the '*' wildcard and a trailing '$' anchor.
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Robots files larger than this are truncated, as recommended by
+	// the RFC.
+	maxRobotsSize = 500 * 1024
+
+	// The RFC asks that we follow at least five redirects, to any
+	// host, in fetching a robots.txt.
+	maxRobotsRedirects = 5
+)
+
+// The robotsRules are the parsed directives from one robots.txt file
+// that apply to our user agent.
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+	sitemaps   []string
+}
+
+// A single Allow or Disallow line.
+type robotsRule struct {
+	pattern string
+	allow   bool
+}
+
+// The robotsCache fetches each host's robots.txt once and remembers the
+// result for the rest of the crawl.  It is safe for concurrent use.
+type robotsCache struct {
+	client *http.Client
+	agent  string
+	mu     sync.Mutex
+	hosts  map[string]*robotsRules
+
+	// If set, waits for our turn to send a request to the URL's host.
+	pace func(ctx context.Context, u *url.URL) error
+}
+
+// Creates a new robots cache that matches groups against the product
+// token of the given User-Agent string.  The robots.txt requests are
+// sent with the client's transport and timeout, but follow redirects
+// out of the crawl's scope.
+func newRobotsCache(client *http.Client, userAgent string) *robotsCache {
+	rc := &robotsCache{
+		agent: productToken(userAgent),
+		hosts: make(map[string]*robotsRules),
+	}
+	rc.client = &http.Client{
+		Transport: client.Transport,
+		Timeout:   client.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRobotsRedirects {
+				return http.ErrUseLastResponse
+			}
+			return rc.wait(req.Context(), req.URL)
+		},
+	}
+	return rc
+}
+
+// Waits for our turn to send a request to the URL's host, if a pace
+// function has been set.  Returns an error if the context is done
+// first.
+func (rc *robotsCache) wait(ctx context.Context, u *url.URL) error {
+	if rc.pace == nil {
+		return nil
+	}
+	return rc.pace(ctx, u)
+}
+
+// Reports whether our agent may fetch the given URL, fetching and
+// parsing the host's robots.txt first if we haven't seen it before.
+func (rc *robotsCache) allowed(ctx context.Context, u *url.URL) bool {
+	return rc.rulesFor(ctx, u).allowed(u.EscapedPath(), u.RawQuery)
+}
+
+// Returns the Crawl-delay requested for the URL's host, or zero if the
+// host's robots.txt hasn't been fetched or doesn't specify one.
+func (rc *robotsCache) crawlDelay(u *url.URL) time.Duration {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if r := rc.hosts[robotsKey(u)]; r != nil {
+		return r.crawlDelay
+	}
+	return 0
+}
+
+// Returns the rules for the URL's host, fetching them if need be.
+func (rc *robotsCache) rulesFor(ctx context.Context, u *url.URL) *robotsRules {
+	key := robotsKey(u)
+	rc.mu.Lock()
+	r := rc.hosts[key]
+	rc.mu.Unlock()
+	if r != nil {
+		return r
+	}
+
+	r = rc.fetch(ctx, key)
+	rc.mu.Lock()
+	rc.hosts[key] = r
+	rc.mu.Unlock()
+	return r
+}
+
+// Fetches and parses the robots.txt at the given scheme and host.  Per
+// the RFC, a missing file (4xx), or one that still redirects after the
+// most redirects we follow, means everything is allowed, while a server
+// or network error means we must assume nothing is.
+func (rc *robotsCache) fetch(ctx context.Context, key string) *robotsRules {
+	disallowAll := &robotsRules{rules: []robotsRule{{pattern: "/"}}}
+	req, err := http.NewRequest(http.MethodGet, key+"/robots.txt", nil)
+	if err != nil {
+		log.Printf("error creating robots.txt request for '%s': %v\n", key, err)
+		return disallowAll
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", *userAgent)
+	if err := rc.wait(ctx, req.URL); err != nil {
+		return disallowAll
+	}
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		if !isCancel(err) {
+			log.Printf("error fetching robots.txt for '%s': %v\n", key, err)
+		}
+		return disallowAll
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500:
+		return disallowAll
+	case resp.StatusCode >= 300:
+		return &robotsRules{}
+	}
+	return parseRobots(io.LimitReader(resp.Body, maxRobotsSize), rc.agent)
+}
+
+// Parses a robots.txt file, keeping only the rules from the groups that
+// apply to the given product token.  If no group names our agent, the
+// "*" groups are used instead.
+func parseRobots(r io.Reader, agent string) *robotsRules {
+	var mine, star robotsRules
+	var haveMine bool
+
+	// The agents named by the group being read, and whether we've
+	// seen a rule line yet, which tells us when a new group starts.
+	var forMe, forStar, inRules bool
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			line = line[:i]
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+
+		var dst []*robotsRules
+		if forMe {
+			dst = append(dst, &mine)
+		}
+		if forStar {
+			dst = append(dst, &star)
+		}
+
+		switch k {
+		case "user-agent":
+			if inRules {
+				forMe, forStar, inRules = false, false, false
+			}
+			name := strings.ToLower(productToken(v))
+			if name == "*" {
+				forStar = true
+			} else if name != "" && name == strings.ToLower(agent) {
+				forMe, haveMine = true, true
+			}
+		case "allow", "disallow":
+			inRules = true
+			if v == "" {
+				// An empty Disallow matches nothing.
+				continue
+			}
+			for _, d := range dst {
+				d.rules = append(d.rules,
+					robotsRule{pattern: v, allow: k == "allow"})
+			}
+		case "crawl-delay":
+			inRules = true
+			secs, err := strconv.ParseFloat(v, 64)
+			if err != nil || secs < 0 {
+				continue
+			}
+			for _, d := range dst {
+				d.crawlDelay = time.Duration(secs * float64(time.Second))
+			}
+		case "sitemap":
+			// Sitemaps aren't tied to any group.
+			mine.sitemaps = append(mine.sitemaps, v)
+			star.sitemaps = append(star.sitemaps, v)
+		}
+	}
+
+	if haveMine {
+		return &mine
+	}
+	return &star
+}
+
+// Reports whether the path (and optional query) may be fetched.  The
+// longest matching pattern decides, with Allow winning a tie.  The
+// robots.txt file itself is always allowed.
+func (r *robotsRules) allowed(path, query string) bool {
+	if path == "" {
+		path = "/"
+	}
+	if path == "/robots.txt" {
+		return true
+	}
+	if query != "" {
+		path += "?" + query
+	}
+
+	best := -1
+	allow := true
+	for _, rule := range r.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		l := len(rule.pattern)
+		if l > best || (l == best && rule.allow) {
+			best = l
+			allow = rule.allow
+		}
+	}
+	return allow
+}
+
+// Matches a robots.txt path pattern against a path.  Patterns are
+// prefix matches, except that '*' matches any run of characters and a
+// trailing '$' requires the path to end there.
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[:len(pattern)-1]
+	}
+
+	// Classic wildcard matching with backtracking to the most
+	// recent star.
+	p, s := 0, 0
+	star, mark := -1, 0
+	for s < len(path) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, s
+			p++
+		case p < len(pattern) && pattern[p] == path[s]:
+			p++
+			s++
+		case p == len(pattern) && !anchored:
+			// The whole pattern matched a prefix of the path.
+			return true
+		case star != -1:
+			p = star + 1
+			mark++
+			s = mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// The cache key for a URL's robots.txt, which is specific to the
+// scheme, host and port.
+func robotsKey(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// Extracts the product token from a User-Agent string, for example
+// "site_word_freq" from "site_word_freq/1.0 (+http://...)".
+func productToken(ua string) string {
+	ua = strings.TrimSpace(ua)
+	if i := strings.IndexAny(ua, "/ "); i != -1 {
+		ua = ua[:i]
+	}
+	return ua
+}
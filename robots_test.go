@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test group selection and rule precedence in a robots.txt file.
+func TestRobotsRules(t *testing.T) {
+	robots := `
+# Comments and blank lines are ignored.
+User-agent: *
+Disallow: /private/
+Allow: /private/ok.html
+Crawl-delay: 2
+
+User-agent: OtherBot
+User-agent: site_word_freq
+Disallow: /search
+Disallow: /*.pdf$
+Allow: /search/about
+Crawl-delay: 0.5
+
+User-agent: somebody-else
+Disallow: /
+
+Sitemap: http://example.com/sitemap.xml
+`
+	r := parseRobots(strings.NewReader(robots), "site_word_freq")
+	if r.crawlDelay != 500*time.Millisecond {
+		t.Fatalf("unexpected crawl delay: %v\n", r.crawlDelay)
+	}
+	if len(r.sitemaps) != 1 || r.sitemaps[0] != "http://example.com/sitemap.xml" {
+		t.Fatalf("unexpected sitemaps: %v\n", r.sitemaps)
+	}
+
+	tests := []struct {
+		path, query string
+		allowed     bool
+	}{
+		{"/", "", true},
+		{"/private/secret.html", "", true},
+		{"/search", "", false},
+		{"/search", "q=go", false},
+		{"/searching", "", false},
+		{"/search/about", "", true},
+		{"/docs/manual.pdf", "", false},
+		{"/docs/manual.pdf", "download=1", true},
+		{"/docs/manual.pdfx", "", true},
+		{"/robots.txt", "", true},
+	}
+	for _, tc := range tests {
+		if got := r.allowed(tc.path, tc.query); got != tc.allowed {
+			t.Errorf("allowed(%q, %q): expected %t, got %t\n",
+				tc.path, tc.query, tc.allowed, got)
+		}
+	}
+
+	// An agent with no group of its own falls back to "*".
+	r = parseRobots(strings.NewReader(robots), "unknown")
+	if r.allowed("/private/secret.html", "") {
+		t.Fatalf("expected '*' group to disallow /private/\n")
+	}
+	if !r.allowed("/private/ok.html", "") {
+		t.Fatalf("expected longer Allow rule to win\n")
+	}
+	if r.crawlDelay != 2*time.Second {
+		t.Fatalf("unexpected crawl delay: %v\n", r.crawlDelay)
+	}
+}
+
+// Test the wildcard and end-anchor pattern matching.
+func TestRobotsMatch(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		match         bool
+	}{
+		{"/", "/anything", true},
+		{"/fish", "/fish.html", true},
+		{"/fish", "/Fish.html", false},
+		{"/fish/", "/fish", false},
+		{"/*.php", "/index.php", true},
+		{"/*.php", "/dir/index.php?x=1", true},
+		{"/*.php$", "/index.php", true},
+		{"/*.php$", "/index.php?x=1", false},
+		{"/fish*", "/fish", true},
+		{"/a*b*c", "/aXbYc", true},
+		{"/a*b*c", "/aXcYb", false},
+		{"/exact$", "/exact", true},
+		{"/exact$", "/exactly", false},
+	}
+	for _, tc := range tests {
+		if got := robotsMatch(tc.pattern, tc.path); got != tc.match {
+			t.Errorf("robotsMatch(%q, %q): expected %t, got %t\n",
+				tc.pattern, tc.path, tc.match, got)
+		}
+	}
+}
+
+// Test that a robots.txt is found through redirects, even to another
+// host, that one which redirects too many times allows everything
+// rather than being parsed as rules, and that each request is paced.
+func TestRobotsRedirects(t *testing.T) {
+	rules := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		w.Write([]byte("User-agent: *\nDisallow: /blocked\n"))
+	}))
+	defer rules.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.Host, "moved"):
+			http.Redirect(w, r, rules.URL+"/robots.txt", http.StatusMovedPermanently)
+		default:
+			// A redirect page whose body looks like rules.
+			w.Header().Set("Location", r.URL.Path+"x")
+			w.WriteHeader(http.StatusFound)
+			w.Write([]byte("User-agent: *\nDisallow: /\n"))
+		}
+	}))
+	defer ts.Close()
+
+	// Hosts named "moved" and "looping" both reach the test server.
+	addr := strings.TrimPrefix(ts.URL, "http://")
+	client := &http.Client{Transport: hostsTransport(addr)}
+	var mu sync.Mutex
+	paced := 0
+	rc := newRobotsCache(client, "testbot/1.0")
+	rc.pace = func(ctx context.Context, u *url.URL) error {
+		mu.Lock()
+		paced++
+		mu.Unlock()
+		return nil
+	}
+
+	tests := []struct {
+		page    string
+		allowed bool
+		paced   int
+	}{
+		{"http://moved/blocked", false, 2},
+		{"http://moved/open", true, 0},
+		{"http://looping/blocked", true, maxRobotsRedirects + 1},
+	}
+	for _, tc := range tests {
+		paced = 0
+		u, _ := url.Parse(tc.page)
+		if rc.allowed(context.Background(), u) != tc.allowed {
+			t.Errorf("%s: expected allowed %t\n", tc.page, tc.allowed)
+		}
+		if paced != tc.paced {
+			t.Errorf("%s: expected %d paced requests, got %d\n", tc.page,
+				tc.paced, paced)
+		}
+	}
+}
@@ -7,34 +7,67 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
 
 	"golang.org/x/net/html"
+	"golang.org/x/text/unicode/norm"
 )
 
 // The SearchRecord is passed to each gorutine to process a given
 // link.  Each link builds a word count of words at least as long
 // as requested length.  These totals are then added in to the grand
 // total.  As each search record has its own error field, this
-//  us an organized way to catalog all the errors that occurred
-// in the processing.
+// gives us an organized way to catalog all the errors that occurred
+// in the processing.  Errors that may go away if we try again, such
+// as timeouts and 5xx responses, are marked transient.
 type searchRecord struct {
-	url string
-	err error
+	url       string
+	depth     int
+	attempt   int
+	err       error
+	transient bool
+}
+
+// The pageDirectives are the robots signals given by a page, either
+// in its markup or in its response headers.  A non-empty canonical URL
+// identifies the page the author considers this one a copy of.
+type pageDirectives struct {
+	noindex   bool
+	nofollow  bool
+	canonical string
+}
+
+// The textPolicy says which text, beyond the page's ordinary prose,
+// is counted: the text of links, and the text held in attributes and
+// meta tags.  Navigation and alt text are often just what an SEO audit
+// is looking for, but they repeat on every page of a site, so they are
+// left out by default.
+type textPolicy struct {
+	linkText  bool // the text inside <a> elements
+	altText   bool // alt attributes of images
+	titleAttr bool // title attributes of any element
+	ariaLabel bool // aria-label attributes of any element
+	metaText  bool // <meta> description and keywords
 }
 
 var (
-	// Match words with Unicode characters, "w" is just ASCII.
+	// Match words with Unicode characters, "w" is just ASCII.  Marks
+	// are included, as in many scripts the vowels are combining marks.
+	// This is the pattern for the "regexp" tokenizer.
 	//words = regexp.MustCompile(`\w+`)
-	words = regexp.MustCompile(`[\p{L}\d_]+`)
+	words = regexp.MustCompile(`[\p{L}\p{M}\d_]+`)
 
 	// Literal unicode values such as '\u0022' may be encountered on a page.
 	// These need to be converted to a Unicode byte sequence because as read
@@ -52,15 +85,31 @@ func (sr searchRecord) processLink(ctx context.Context, wf *WordFinder) {
 	// result channel, even if it is empty data, to
 	// ensure that the count eventually reaches zero.
 	var links []string
-	var words map[string]int
+	var words *wordCounts
+	var dirs pageDirectives
 	defer func() {
-		wf.addLinkData(ctx, sr, words, links)
+		if *ignoreDirectives {
+			dirs = pageDirectives{}
+		}
+		if dirs.noindex {
+			words = nil
+		}
+		if dirs.nofollow {
+			links = nil
+		}
+		wf.addLinkData(ctx, sr, words, links, dirs)
 	}()
 
 	if wf.interrupt {
 		// Short circuit traversal if we are cleaning up.
 		return
 	}
+	if *headCheck && *maxBodyBytes > 0 {
+		if err := wf.checkLength(ctx, sr.url); err != nil {
+			sr.err = err
+			return
+		}
+	}
 	req, err := http.NewRequest(http.MethodGet, sr.url, nil)
 	if err != nil {
 		if !isCancel(err) {
@@ -70,21 +119,30 @@ func (sr searchRecord) processLink(ctx context.Context, wf *WordFinder) {
 		return
 	}
 	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", *userAgent)
+	if err := wf.waitTurn(ctx, req.URL); err != nil {
+		return
+	}
 	resp, err := wf.client.Do(req)
 	if err != nil {
 		if !isCancel(err) {
 			log.Printf("error opening '%s': %v\n", sr.url, err)
 			sr.err = err
+			sr.transient = isTransient(err)
 		}
 		return
 	}
 	defer resp.Body.Close()
+	wf.checkOverload(resp)
 
 	if resp.StatusCode >= 400 {
 		sr.err = fmt.Errorf("HTTP status %d : %s", resp.StatusCode,
 			http.StatusText(resp.StatusCode))
+		sr.transient = resp.StatusCode >= 500 ||
+			resp.StatusCode == http.StatusTooManyRequests
 		return
 	}
+	dirs.parseHeaders(resp.Header.Values("X-Robots-Tag"))
 	ct := resp.Header.Get("Content-type")
 	if ct == "" {
 		return
@@ -95,172 +153,363 @@ func (sr searchRecord) processLink(ctx context.Context, wf *WordFinder) {
 		sr.err = err
 		return
 	}
-	if m == "application/binary" {
+	ext, known := wf.extractorFor(m)
+	if ext == nil {
+		wf.noteSkippedType(m, known)
 		return
 	}
 
-	br := bufio.NewReader(resp.Body)
-	if m == "text/html" {
-		words, links = sr.processHTML(ctx, br, wf.target)
-	} else {
-		words = sr.processAsText(ctx, br)
+	// Whatever was read before the body reached the limit is kept,
+	// but a page that failed to read for any other reason is dropped,
+	// to be counted in full if it's retried.
+	var pd pageDirectives
+	body := newLimitedBody(resp.Body, *maxBodyBytes)
+	words, links, pd, err = ext(ctx, sr, bufio.NewReader(body), ct, wf)
+	switch {
+	case body.exceeded:
+		sr.err = fmt.Errorf("%w: truncated at %d bytes", errBodyTooLarge,
+			*maxBodyBytes)
+	case err != nil && !isCancel(err) && ctx.Err() == nil:
+		log.Printf("error reading '%s': %v\n", sr.url, err)
+		sr.err = err
+		sr.transient = isTransient(err)
+		words, links = nil, nil
+		return
 	}
+	dirs.noindex = dirs.noindex || pd.noindex
+	dirs.nofollow = dirs.nofollow || pd.nofollow
+	dirs.canonical = pd.canonical
 }
 
-func (sr searchRecord) processHTML(ctx context.Context,
-	r io.Reader, target string) (map[string]int, []string) {
+// Counts the words of an HTML page, and gathers its links and robots
+// directives.  The error is any failure to read the page.
+func (sr searchRecord) processHTML(ctx context.Context, r io.Reader,
+	wf *WordFinder) (*wordCounts, []string, pageDirectives, error) {
 
-	var baseURL *url.URL
+	var dirs pageDirectives
 	base := sr.url
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		log.Printf("Warning: URL parse error: %v\n", err)
+		return nil, nil, dirs, nil
+	}
 
 	links := make([]string, 0)
-	wds := make(map[string]int)
+	wds := newWordCounts(0, wf.weights != nil)
 	z := html.NewTokenizer(r)
-	inAnchor := false
+
+	// To keep things from ballooning out of control, only crawl
+	// within the current site, as defined by the scope.  The links
+	// are canonicalized, so that the run loop sees each page under
+	// one name only.
+	addLink := func(href string) {
+		if u := resolveLink(baseURL, href); u != nil &&
+			wf.scope.containsURL(u) {
+			links = append(links, wf.canon.canonical(u))
+		}
+	}
+	follows := func(source string) bool { return wf.linkSrcs[source] }
+	hasBase := false
+
+	// The text in elements such as <script> that don't hold the
+	// page's prose is never counted, and anchor text only if the
+	// policy says so.  If weighting is on, the stack also tells us
+	// how much the words in the text are worth.
+	stack := newElementStack(wf.skipElems)
+	policy := wf.policy
 	for {
 		tt := z.Next()
 		switch tt {
 		case html.ErrorToken:
 			// Reading EOF is the normal end of processsing for
-			// the page.  Regardless of the error, we'll send what
-			// we have to the  channel.
+			// the page.  Any other error is left to the caller.
 			e := z.Err()
-			if e != io.EOF && e != context.Canceled {
-				sr.err = z.Err()
-				log.Printf("error parsing '%s': %v\n", base,
-					e)
+			if e == io.EOF || e == context.Canceled {
+				e = nil
 			}
-			return wds, links
+			return wds, links, dirs, e
 		case html.TextToken:
-			if !inAnchor {
-				scanText(string(z.Text()), wds)
+			if !stack.skipping() &&
+				(policy.linkText || !stack.inside("a")) {
+				scanText(string(z.Text()), wds, stack.weight(wf.weights))
 			}
-			inAnchor = false
-		case html.StartTagToken:
-			// If the next tag is an anchor, extract the 'href'.
+		case html.StartTagToken, html.SelfClosingTagToken:
 			tn, hasAttr := z.TagName()
-			if (len(tn) != 1 || tn[0] != 'a') || !hasAttr {
+			name := string(tn)
+			if tt == html.StartTagToken {
+				stack.push(name)
+			} else {
+				stack.pushSelfClosing(name)
+			}
+
+			// Only gather the attributes of the tags that might
+			// interest us, as it is relatively expensive.
+			if !hasAttr || (!policy.wantsAttrs() && !linkElements[name]) {
 				continue
 			}
-			inAnchor = true
-			more := true
-			for {
-				if !more {
-					break
-				}
-				k, v, m := z.TagAttr()
-				more = m
+			attrs := tagAttrs(z)
+			if !stack.skipping() {
+				policy.scanAttrs(name, attrs, wds,
+					stack.weight(wf.weights))
+			}
 
-				// Skip if it's not an 'href'.
-				attr := string(k)
-				if attr != "href" {
-					continue
+			// Links the page author asked us not to follow are
+			// skipped, whatever element they come from.
+			nofollow := !*ignoreDirectives &&
+				hasToken(attrs["rel"], "nofollow")
+			switch name {
+			case "base":
+				// Relative links after the first <base href> are
+				// resolved against it rather than the page URL.
+				if !hasBase {
+					if u := resolveLink(baseURL, attrs["href"]); u != nil {
+						baseURL = u
+						hasBase = true
+					}
 				}
-
-				// Skip fragment links to the same page
-				// (i.e. the entire link is a fragment),
-				// as well as "{...}" templates.
-				av := strings.TrimSpace(string(v))
-				if strings.HasPrefix(av, "#") ||
-					strings.HasPrefix(av, "{") {
-					continue
+			case "a", "area":
+				if follows(name) && !nofollow {
+					addLink(attrs["href"])
 				}
-
-				// Fix broken query strings using the wrong escape
-				// escape sequence for blank.  Go expects "+"", not
-				// "%20", in the query string.
-				qndx := strings.LastIndexByte(av, '?')
-				if qndx != -1 {
-					q := av[qndx:]
-					if strings.Contains(q, "%20") {
-						nstr := strings.Replace(q, "%20", "+", -1)
-						av = av[:qndx] + nstr
+			case "frame", "iframe":
+				if follows(name) && !nofollow {
+					addLink(attrs["src"])
+				}
+			case "form":
+				// Only forms submitted with GET lead to a page
+				// we can fetch, and one without an action just
+				// submits to the page itself.
+				method := strings.ToLower(strings.TrimSpace(attrs["method"]))
+				action := strings.TrimSpace(attrs["action"])
+				if follows(name) && !nofollow && action != "" &&
+					(method == "" || method == "get") {
+					addLink(action)
+				}
+			case "link":
+				if hasToken(attrs["rel"], "canonical") {
+					if u := resolveLink(baseURL, attrs["href"]); u != nil {
+						dirs.canonical = wf.canon.canonical(u)
 					}
 				}
 
-				// Make sure the url is valid format.
-				u, err := url.Parse(av)
-				if err != nil {
-					log.Printf(
-						"Warning: from '%s': parse error on '%s': %v\n",
-						base, av, err)
+				// Follow links to the site's feeds, which lead
+				// on to their entries, and to the next and
+				// previous pages of a series.
+				if nofollow {
 					continue
 				}
-
-				// Remove any fragment, as it is just a location
-				// within a page, and we don't want to scan two
-				// pages that are otherwsie identical twice.
-				if u.Fragment != "" {
-					u.Fragment = ""
-					av = u.String()
-					u, err = url.Parse(av)
-					if err != nil {
-						log.Printf("Warning: (re)-parse error: %v\n", err)
-						continue
-					}
+				if *feeds && hasToken(attrs["rel"], "alternate") &&
+					feedTypes[strings.ToLower(attrs["type"])] {
+					addLink(attrs["href"])
+				} else if (follows("next") && hasToken(attrs["rel"], "next")) ||
+					(follows("prev") && (hasToken(attrs["rel"], "prev") ||
+						hasToken(attrs["rel"], "previous"))) {
+					addLink(attrs["href"])
+				}
+			case "meta":
+				mn := strings.ToLower(attrs["name"])
+				if mn == "robots" ||
+					mn == strings.ToLower(productToken(*userAgent)) {
+					dirs.parse(attrs["content"])
 				}
+				if follows("refresh") &&
+					strings.EqualFold(attrs["http-equiv"], "refresh") {
+					addLink(refreshURL(attrs["content"]))
+				}
+			}
+		case html.EndTagToken:
+			tn, _ := z.TagName()
+			stack.pop(string(tn))
+		}
+	}
+}
 
-				// Ensure that we have a full url.
-				if !u.IsAbs() {
-					if baseURL == nil {
-						baseURL, err = url.Parse(base)
-						if err != nil {
-							log.Printf("Warning: URL parse error: %v\n", err)
-							continue
-						}
-					}
+// Reports whether the policy counts text from any attributes.
+func (tp textPolicy) wantsAttrs() bool {
+	return tp.altText || tp.titleAttr || tp.ariaLabel || tp.metaText
+}
 
-					u = baseURL.ResolveReference(u)
-					av = u.String()
-				}
+// Counts the words in the tag's attributes, as the policy allows.
+func (tp textPolicy) scanAttrs(tag string, attrs map[string]string,
+	wds *wordCounts, weight float64) {
+	if tp.altText && (tag == "img" || tag == "area" ||
+		(tag == "input" && strings.EqualFold(attrs["type"], "image"))) {
+		scanText(attrs["alt"], wds, weight)
+	}
+	if tp.titleAttr {
+		scanText(attrs["title"], wds, weight)
+	}
+	if tp.ariaLabel {
+		scanText(attrs["aria-label"], wds, weight)
+	}
+	if tp.metaText && tag == "meta" {
+		switch strings.ToLower(attrs["name"]) {
+		case "description", "keywords":
+			scanText(attrs["content"], wds, weight)
+		}
+	}
+}
+
+// Adds the directives from a robots meta tag, or one X-Robots-Tag
+// header, to those already seen.  Only "noindex", "nofollow" and "none"
+// (meaning both) concern us.
+func (pd *pageDirectives) parse(content string) {
+	for _, d := range strings.Split(content, ",") {
+		switch strings.ToLower(strings.TrimSpace(d)) {
+		case "noindex":
+			pd.noindex = true
+		case "nofollow":
+			pd.nofollow = true
+		case "none":
+			pd.noindex = true
+			pd.nofollow = true
+		}
+	}
+}
 
-				// To keep things from ballooning out of
-				// control, only crawl within the current site,
-				// or a reasonable stab at such an equivalency.
-				if strings.HasSuffix(u.Hostname(), target) {
-					links = append(links, av)
+// Adds the directives from the X-Robots-Tag headers.  A header may be
+// aimed at one crawler, as in "otherbot: noindex", in which case it is
+// ignored unless it names us.
+func (pd *pageDirectives) parseHeaders(values []string) {
+	agent := productToken(*userAgent)
+	for _, v := range values {
+		if k, rest, ok := strings.Cut(v, ":"); ok {
+			k = strings.TrimSpace(k)
+			if !strings.ContainsAny(k, ", ") && !xRobotsValued[strings.ToLower(k)] {
+				if !strings.EqualFold(k, agent) {
+					continue
 				}
+				v = rest
 			}
-		case html.EndTagToken:
-			inAnchor = false
 		}
+		pd.parse(v)
 	}
 }
 
+// X-Robots-Tag directives that take a value after a colon, which
+// must not be mistaken for a crawler name.
+var xRobotsValued = map[string]bool{
+	"unavailable_after": true,
+	"max-snippet":       true,
+	"max-image-preview": true,
+	"max-video-preview": true,
+}
+
+// Resolves a link found on a page against the page's URL, returning
+// nil for links we don't want to follow.
+func resolveLink(baseURL *url.URL, href string) *url.URL {
+	// Skip fragment links to the same page
+	// (i.e. the entire link is a fragment),
+	// as well as "{...}" templates.
+	av := strings.TrimSpace(href)
+	if av == "" || strings.HasPrefix(av, "#") ||
+		strings.HasPrefix(av, "{") {
+		return nil
+	}
+
+	// Fix broken query strings using the wrong escape
+	// escape sequence for blank.  Go expects "+"", not
+	// "%20", in the query string.
+	qndx := strings.LastIndexByte(av, '?')
+	if qndx != -1 {
+		q := av[qndx:]
+		if strings.Contains(q, "%20") {
+			nstr := strings.Replace(q, "%20", "+", -1)
+			av = av[:qndx] + nstr
+		}
+	}
+
+	// Make sure the url is valid format.
+	u, err := url.Parse(av)
+	if err != nil {
+		log.Printf("Warning: from '%s': parse error on '%s': %v\n",
+			baseURL, av, err)
+		return nil
+	}
+
+	// Remove any fragment, as it is just a location
+	// within a page, and we don't want to scan two
+	// pages that are otherwsie identical twice.
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	// Ensure that we have a full url.
+	if !u.IsAbs() {
+		u = baseURL.ResolveReference(u)
+	}
+	return u
+}
+
+// Returns the attributes of the current tag, keyed by lowercase name.
+// Only the first of any repeated attribute is kept.
+func tagAttrs(z *html.Tokenizer) map[string]string {
+	attrs := make(map[string]string)
+	for {
+		k, v, more := z.TagAttr()
+		if _, ok := attrs[string(k)]; !ok {
+			attrs[string(k)] = string(v)
+		}
+		if !more {
+			return attrs
+		}
+	}
+}
+
+// Reports whether a space-separated attribute value, such as "rel",
+// contains the token.
+func hasToken(value, token string) bool {
+	for _, f := range strings.Fields(value) {
+		if strings.EqualFold(f, token) {
+			return true
+		}
+	}
+	return false
+}
+
 // Take a swag at parsing the content as line-oriented text.
 func (sr searchRecord) processAsText(ctx context.Context,
-	br *bufio.Reader) map[string]int {
-	wds := make(map[string]int)
+	br *bufio.Reader, wf *WordFinder) (*wordCounts, error) {
+	wds := newWordCounts(0, wf.weights != nil)
 	for {
 		b, err := br.ReadBytes('\n')
 		if err != nil && err != io.EOF {
-			sr.err = err
-			break
+			return wds, err
 		}
 		if b != nil && len(b) > 0 {
-			scanText(string(b), wds)
+			scanText(string(b), wds, 1)
 		}
 		if err == io.EOF {
 			break
 		}
 	}
-	return wds
+	return wds, nil
 }
 
 // Extract words from text.  If they are long enough, record
-// them in the map.
-func scanText(text string, wds map[string]int) {
-	text = convertUnicodeEscapes(text)
-	res := words.FindAllString(text, -1)
-	if len(res) > 0 {
-		for _, v := range res {
-			length := uint(len(v))
+// them in the counts with the given weight, along with any phrases
+// if they are being counted.  The text is normalized to NFC first, so
+// that composed and decomposed forms of the same word are counted
+// together.
+func scanText(text string, wds *wordCounts, weight float64) {
+	text = norm.NFC.String(convertUnicodeEscapes(text))
+	spans := tokenizer.Tokens(text, nil)
+	if len(spans) > 0 {
+		for _, sp := range spans {
+			v := text[sp.start:sp.end]
+			length := wordLength(v)
 			if (length >= *minLen) &&
 				(*maxLen == 0 || length <= *maxLen) &&
 				(strings.IndexByte(v, '_') == -1) {
-				wds[v]++
+				if isStopword(v) {
+					wds.stopped++
+					continue
+				}
+				wds.add(normalizeWord(v), v, weight)
 			}
 		}
+		if wds.phrases != nil {
+			scanPhrases(text, spans, wds.phrases, weight)
+		}
 	}
 }
 
@@ -309,6 +558,30 @@ func convertUnicodeEscapes(text string) string {
 	return string(res)
 }
 
+// Reports whether a failure to fetch or read a page may go away if we
+// try again.  Hosts that don't exist, certificates that don't verify
+// and URLs we can't fetch at all won't fix themselves.
+func isTransient(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var certErr *tls.CertificateVerificationError
+	var hostErr x509.HostnameError
+	var authErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	if errors.As(err, &certErr) || errors.As(err, &hostErr) ||
+		errors.As(err, &authErr) || errors.As(err, &invalidErr) ||
+		errors.As(err, &recordErr) || errors.As(err, &alertErr) {
+		return false
+	}
+
+	// The http package has no error value for these.
+	return !strings.Contains(err.Error(), "unsupported protocol scheme")
+}
+
 func isCancel(err error) bool {
 	if err == nil || err == context.Canceled {
 		return true
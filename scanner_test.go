@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Creates a finder for calling the page processing functions directly.
+func testFinder(t *testing.T) *WordFinder {
+	u, err := url.Parse("http://example.com/")
+	if err != nil {
+		t.Fatalf("URL parse failed: %v\n", err)
+	}
+	wf, err := newWordFinder([]*url.URL{u}, newFormatter())
+	if err != nil {
+		t.Fatalf("creating finder failed: %v\n", err)
+	}
+	return wf
+}
+
+// Returns the sorted words found in an HTML fragment.
+func htmlWords(t *testing.T, wf *WordFinder, page string) []string {
+	sr := searchRecord{url: "http://example.com/"}
+	wds, _, _, _ := sr.processHTML(context.Background(),
+		strings.NewReader(page), wf)
+	var res []string
+	for k := range wds.counts {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Test that text inside script, style and similar elements is skipped,
+// including when they are nested or the markup is malformed.
+func TestSkipElements(t *testing.T) {
+	*minLen = 4
+	*maxLen = 0
+	wf := testFinder(t)
+	tests := []struct {
+		page     string
+		expected string
+	}{
+		{`<p>prose <script>var function return</script> after</p>`,
+			"after prose"},
+		{`<style>.display { width: 10px }</style><div>words</div>`,
+			"words"},
+		{`<noscript>enable javascript</noscript><b>visible</b>`,
+			"visible"},
+		{`<template><p>hidden <span>deep</span></p></template>shown`,
+			"shown"},
+		{`<svg><g><text>label</text></g></svg>plain`,
+			"plain"},
+
+		// Anchor text isn't counted, even in nested elements.
+		{`<a href="/x">link <span>nested</span></a> outside`,
+			"outside"},
+
+		// A stray end tag doesn't close anything.
+		{`<template>one</div>two</template>three`,
+			"three"},
+
+		// Unclosed elements inside a skipped one are closed with it.
+		{`<template><div><p>inner</template>after`,
+			"after"},
+
+		// Void elements and self-closing tags don't stay open.
+		{`<svg><path d="M0"/></svg>text<br>more<img src="x">tail`,
+			"more tail text"},
+
+		// Except in HTML, where the slash is ignored.
+		{`<script src="x.js" />var function return</script>after`,
+			"after"},
+		{`<style/>.hidden { display: none }</style><p>shown</p>`,
+			"shown"},
+		{`<template/>hidden</template><svg/>drawn`,
+			"drawn"},
+
+		// An unclosed skipped element swallows the rest.
+		{`before <template>never closed`,
+			"before"},
+	}
+	for _, tc := range tests {
+		got := strings.Join(htmlWords(t, wf, tc.page), " ")
+		if got != tc.expected {
+			t.Errorf("%q: expected %q, got %q\n", tc.page, tc.expected, got)
+		}
+	}
+
+	// The list of skipped elements is configurable.
+	wf.skipElems = elementSet("STYLE, code")
+	got := strings.Join(htmlWords(t, wf,
+		`<script>scripted</script><code>coded</code><style>styled</style>`),
+		" ")
+	if got != "scripted" {
+		t.Errorf("unexpected words with custom list: %q\n", got)
+	}
+}
+
+// Test that each part of the text policy brings in its own text.
+func TestTextPolicy(t *testing.T) {
+	*minLen = 4
+	*maxLen = 0
+	wf := testFinder(t)
+	page := `<head><meta name="description" content="described">
+		<meta name="keywords" content="keyword, another">
+		<meta name="author" content="nobody"></head>
+		<body>prose <a href="/x" title="linktitle">linked</a>
+		<img src="a.png" alt="pictured"> <div aria-label="labelled"></div>
+		<input type="image" alt="buttoned"> <span title="spanned"></span>
+		<script><img alt="scripted"></script></body>`
+
+	tests := []struct {
+		policy   textPolicy
+		expected string
+	}{
+		{textPolicy{}, "prose"},
+		{textPolicy{linkText: true}, "linked prose"},
+		{textPolicy{altText: true}, "buttoned pictured prose"},
+		{textPolicy{titleAttr: true}, "linktitle prose spanned"},
+		{textPolicy{ariaLabel: true}, "labelled prose"},
+		{textPolicy{metaText: true}, "another described keyword prose"},
+	}
+	for _, tc := range tests {
+		wf.policy = tc.policy
+		got := strings.Join(htmlWords(t, wf, page), " ")
+		if got != tc.expected {
+			t.Errorf("%+v: expected %q, got %q\n", tc.policy, tc.expected, got)
+		}
+	}
+}
+
+// Test that words are weighted by their most heavily weighted
+// enclosing element, and that raw counts are kept alongside.
+func TestWeights(t *testing.T) {
+	*minLen = 4
+	*maxLen = 0
+	path := filepath.Join(t.TempDir(), "weights")
+	err := os.WriteFile(path, []byte("# custom weights\nH1 10\n\nfooter 0.5\n"),
+		0644)
+	if err != nil {
+		t.Fatalf("writing weights failed: %v\n", err)
+	}
+	wf := testFinder(t)
+	wf.weights, err = loadWeights(path)
+	if err != nil {
+		t.Fatalf("loading weights failed: %v\n", err)
+	}
+
+	sr := searchRecord{url: "http://example.com/"}
+	wds, _, _, _ := sr.processHTML(context.Background(), strings.NewReader(
+		`<title>word</title><h1>word <strong>word</strong></h1>
+		<h2>word</h2><p>word <b>word</b></p><footer>word</footer>`), wf)
+	if wds.counts["word"] != 7 {
+		t.Fatalf("expected 7 raw counts, got %d\n", wds.counts["word"])
+	}
+
+	// title 5 + h1 10 + h1 10 + h2 3 + 1 + b 1.5 + footer 0.5.
+	if w := wds.weights["word"]; w != 31 {
+		t.Fatalf("unexpected weighted total: %v\n", w)
+	}
+
+	for _, bad := range []string{"h1\n", "h1 heavy\n", "h1 -1\n"} {
+		os.WriteFile(path, []byte(bad), 0644)
+		if _, err := loadWeights(path); err == nil {
+			t.Errorf("expected error for %q\n", bad)
+		}
+	}
+}
+
+// Test that links are taken from each configured source, resolved
+// against any <base href>, that forms are followed only when asked and
+// have an action, and that rel="nofollow" is honored on every source.
+func TestLinkSources(t *testing.T) {
+	page := `<head><base href="/docs/">
+		<link rel="next" href="page2"><link rel="prev" href="page0">
+		<link rel="next nofollow" href="page3">
+		<link rel="stylesheet" href="site.css">
+		<meta http-equiv="refresh" content="30; URL='moved'">
+		<base href="/ignored/"></head>
+		<body><a href="intro">x</a> <a rel="nofollow" href="secret">x</a>
+		<map><area href="mapped"></map>
+		<iframe src="framed"></iframe> <frameset><frame src="/top"></frameset>
+		<iframe rel="nofollow" src="hidden"></iframe>
+		<form action="search"></form> <form method="post" action="submit"></form>
+		<form></form> <form action=" "></form>
+		<a href="http://elsewhere.invalid/">x</a></body>`
+
+	tests := []struct {
+		sources  string
+		expected string
+	}{
+		{defaultLinkSources, "framed intro mapped moved page0 page2 top"},
+		{"a,form", "intro search"},
+		{"next", "page2"},
+	}
+	for _, tc := range tests {
+		wf := testFinder(t)
+		var err error
+		wf.linkSrcs, err = linkSourceSet(tc.sources)
+		if err != nil {
+			t.Fatalf("parsing %q failed: %v\n", tc.sources, err)
+		}
+		sr := searchRecord{url: "http://example.com/index.html"}
+		_, links, _, _ := sr.processHTML(context.Background(),
+			strings.NewReader(page), wf)
+		var got []string
+		for _, l := range links {
+			got = append(got, strings.TrimPrefix(
+				strings.TrimPrefix(l, "http://example.com/docs/"),
+				"http://example.com/"))
+		}
+		sort.Strings(got)
+		if strings.Join(got, " ") != tc.expected {
+			t.Errorf("%s: expected %q, got %q\n", tc.sources, tc.expected,
+				strings.Join(got, " "))
+		}
+	}
+
+	if _, err := linkSourceSet("a,img"); err == nil {
+		t.Errorf("expected error for unknown link source\n")
+	}
+	for content, expected := range map[string]string{
+		"0;url=/next":     "/next",
+		"5, URL = \"/q\"": "/q",
+		"10":              "",
+		"3; nothing=/x":   "",
+	} {
+		if got := refreshURL(content); got != expected {
+			t.Errorf("%q: expected %q, got %q\n", content, expected, got)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// The scope decides which hosts the crawl is allowed to wander onto.
+// It is used both for the links found on pages and for redirects, so
+// that the two can never disagree.
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// The ways in which the crawl may be restricted.
+type scopeMode int
+
+const (
+	// Only the start URLs' hosts.
+	scopeExact scopeMode = iota
+
+	// The start URLs' hosts (less any "www.") and their subdomains.
+	scopeSubdomains
+
+	// Any host under the start URLs' registrable domains, as
+	// determined by the public suffix list.
+	scopeDomain
+
+	// The start URLs' hosts plus an explicit list of hosts.
+	scopeHosts
+)
+
+var scopeModes = map[string]scopeMode{
+	"exact":      scopeExact,
+	"subdomains": scopeSubdomains,
+	"domain":     scopeDomain,
+	"hosts":      scopeHosts,
+}
+
+// A Scope reports whether a host is within the bounds of the crawl.
+// With several start URLs, the scope is the union of the scopes of
+// each of them.
+type Scope struct {
+	mode  scopeMode
+	hosts map[string]bool // hosts matched exactly
+	bases map[string]bool // domains matched with their subdomains
+}
+
+// Creates a new scope of the named mode around the start URLs.  The
+// extra hosts are only used by the "hosts" mode.
+func newScope(mode string, starts []*url.URL, extra []string) (*Scope, error) {
+	m, ok := scopeModes[mode]
+	if !ok {
+		return nil, fmt.Errorf("unknown scope mode '%s'", mode)
+	}
+	s := &Scope{
+		mode:  m,
+		hosts: make(map[string]bool),
+		bases: make(map[string]bool),
+	}
+	for _, start := range starts {
+		host := normalizeHost(start.Hostname())
+		if host == "" {
+			return nil, fmt.Errorf("start URL '%s' has no host", start)
+		}
+
+		switch {
+		case m == scopeExact || m == scopeHosts:
+			s.hosts[host] = true
+		case net.ParseIP(host) != nil:
+			// Subdomains and registrable domains make no sense
+			// for an IP address.
+			s.hosts[host] = true
+		case m == scopeSubdomains:
+			s.bases[strings.TrimPrefix(host, "www.")] = true
+		case m == scopeDomain:
+			d, err := publicsuffix.EffectiveTLDPlusOne(host)
+			if err != nil {
+				return nil, fmt.Errorf("cannot find domain of '%s': %v",
+					host, err)
+			}
+			s.bases[d] = true
+		}
+	}
+
+	if m == scopeHosts {
+		for _, h := range extra {
+			if h = normalizeHost(h); h != "" {
+				s.hosts[h] = true
+			}
+		}
+	}
+	return s, nil
+}
+
+// Reports whether the host is within the scope.  The host must not
+// include a port.
+func (s *Scope) contains(host string) bool {
+	host = normalizeHost(host)
+	if s.hosts[host] {
+		return true
+	}
+	switch s.mode {
+	case scopeSubdomains:
+		// Try the host and each of its parent domains, which only
+		// matches on a label boundary, so "example.com" does not
+		// take in "badexample.com".
+		for h := host; ; {
+			if s.bases[h] {
+				return true
+			}
+			i := strings.IndexByte(h, '.')
+			if i == -1 {
+				return false
+			}
+			h = h[i+1:]
+		}
+	case scopeDomain:
+		d, err := publicsuffix.EffectiveTLDPlusOne(host)
+		return err == nil && s.bases[d]
+	}
+	return false
+}
+
+// Reports whether the URL's host is within the scope.
+func (s *Scope) containsURL(u *url.URL) bool {
+	return s.contains(u.Hostname())
+}
+
+// Host names are case-insensitive, and may have a trailing dot.
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+// Table-driven tests of the scope modes and their edge cases.
+func TestScope(t *testing.T) {
+	tests := []struct {
+		mode  string
+		start string
+		extra []string
+		host  string
+		in    bool
+	}{
+		// Exact host only.
+		{"exact", "http://www.example.com/", nil, "www.example.com", true},
+		{"exact", "http://www.example.com/", nil, "WWW.Example.COM.", true},
+		{"exact", "http://www.example.com/", nil, "example.com", false},
+		{"exact", "http://www.example.com/", nil, "docs.example.com", false},
+
+		// Host and subdomains on a label boundary.
+		{"subdomains", "http://www.example.com/", nil, "example.com", true},
+		{"subdomains", "http://www.example.com/", nil, "www.example.com", true},
+		{"subdomains", "http://www.example.com/", nil, "docs.example.com", true},
+		{"subdomains", "http://www.example.com/", nil, "a.b.example.com", true},
+		{"subdomains", "http://www.example.com/", nil, "badexample.com", false},
+		{"subdomains", "http://www.example.com/", nil, "example.com.evil.org", false},
+		{"subdomains", "http://docs.example.com/", nil, "example.com", false},
+		{"subdomains", "http://docs.example.com/", nil, "api.docs.example.com", true},
+
+		// Registrable domain, using the public suffix list.
+		{"domain", "http://docs.example.com/", nil, "example.com", true},
+		{"domain", "http://docs.example.com/", nil, "blog.example.com", true},
+		{"domain", "http://docs.example.com/", nil, "badexample.com", false},
+		{"domain", "http://www.example.co.uk/", nil, "shop.example.co.uk", true},
+		{"domain", "http://www.example.co.uk/", nil, "other.co.uk", false},
+		{"domain", "http://alice.github.io/", nil, "bob.github.io", false},
+		{"domain", "http://alice.github.io/", nil, "www.alice.github.io", true},
+
+		// Explicit allow-list, which always includes the start host.
+		{"hosts", "http://example.com/", []string{"blog.example.org"}, "example.com", true},
+		{"hosts", "http://example.com/", []string{"Blog.Example.org "}, "blog.example.org", true},
+		{"hosts", "http://example.com/", []string{"blog.example.org"}, "www.example.com", false},
+		{"hosts", "http://example.com/", nil, "evil.org", false},
+
+		// IP addresses only match themselves.
+		{"subdomains", "http://127.0.0.1:8080/", nil, "127.0.0.1", true},
+		{"domain", "http://127.0.0.1:8080/", nil, "0.0.1", false},
+		{"domain", "http://[::1]:8080/", nil, "::1", true},
+	}
+	for _, tc := range tests {
+		u, err := url.Parse(tc.start)
+		if err != nil {
+			t.Fatalf("URL parse failed: %v\n", err)
+		}
+		s, err := newScope(tc.mode, []*url.URL{u}, tc.extra)
+		if err != nil {
+			t.Fatalf("newScope(%s, %s) failed: %v\n", tc.mode, tc.start, err)
+		}
+		if got := s.contains(tc.host); got != tc.in {
+			t.Errorf("%s scope of %s: contains(%q) expected %t, got %t\n",
+				tc.mode, tc.start, tc.host, tc.in, got)
+		}
+	}
+}
+
+// Test that the scope of several start URLs is the union of each.
+func TestScopeUnion(t *testing.T) {
+	var starts []*url.URL
+	for _, s := range []string{"http://www.example.com/",
+		"https://docs.example.org/", "http://10.0.0.1/"} {
+		u, _ := url.Parse(s)
+		starts = append(starts, u)
+	}
+	tests := map[string]map[string]bool{
+		"exact": {"www.example.com": true, "docs.example.org": true,
+			"example.com": false, "10.0.0.1": true},
+		"subdomains": {"blog.example.com": true, "api.docs.example.org": true,
+			"example.org": false, "10.0.0.1": true, "badexample.com": false},
+		"domain": {"blog.example.com": true, "example.org": true,
+			"www.example.net": false, "10.0.0.1": true},
+	}
+	for mode, hosts := range tests {
+		s, err := newScope(mode, starts, nil)
+		if err != nil {
+			t.Fatalf("newScope(%s) failed: %v\n", mode, err)
+		}
+		for host, in := range hosts {
+			if got := s.contains(host); got != in {
+				t.Errorf("%s scope: contains(%q) expected %t, got %t\n",
+					mode, host, in, got)
+			}
+		}
+	}
+}
+
+// Test that bad configurations are rejected.
+func TestScopeErrors(t *testing.T) {
+	u, _ := url.Parse("http://example.com/")
+	if _, err := newScope("galaxy", []*url.URL{u}, nil); err == nil {
+		t.Fatalf("expected error for unknown mode\n")
+	}
+	u, _ = url.Parse("/relative/only")
+	if _, err := newScope("exact", []*url.URL{u}, nil); err == nil {
+		t.Fatalf("expected error for missing host\n")
+	}
+	u, _ = url.Parse("http://localhost/")
+	if _, err := newScope("domain", []*url.URL{u}, nil); err == nil {
+		t.Fatalf("expected error for host with no registrable domain\n")
+	}
+}
@@ -2,10 +2,19 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 )
 
 // Mock server
@@ -61,7 +70,10 @@ func TestServer(t *testing.T) {
 	ctx := context.Background()
 	*minLen = 10
 	*maxLen = 0
-	finder := newWordFinder(u, newFormatter())
+	finder, err := newWordFinder([]*url.URL{u}, newFormatter())
+	if err != nil {
+		t.Fatalf("creating finder failed: %v\n", err)
+	}
 	finder.run(ctx)
 	errs := finder.getErrors()
 	if len(errs) != 0 {
@@ -83,6 +95,400 @@ func TestServer(t *testing.T) {
 	}
 }
 
+// Test that the crawl stops at the maximum depth, and that pages are
+// tallied by depth.
+func TestMaxDepth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<a href="/one">one</a> parallelogram`))
+		case "/one":
+			w.Write([]byte(`<a href="/two">two</a> parallelogram`))
+		case "/two":
+			w.Write([]byte(`<a href="/three">three</a> parallelogram`))
+		case "/three":
+			w.Write([]byte(`parallelogram`))
+		}
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("URL parse failed: %v\n", err)
+	}
+	*minLen = 10
+	*maxLen = 0
+	*maxDepth = 1
+	defer func() { *maxDepth = -1 }()
+	finder, err := newWordFinder([]*url.URL{u}, newFormatter())
+	if err != nil {
+		t.Fatalf("creating finder failed: %v\n", err)
+	}
+	finder.run(context.Background())
+
+	depths := finder.getStats().depths
+	if len(depths) != 2 || depths[0] != 1 || depths[1] != 1 {
+		t.Fatalf("unexpected depth counts: %v\n", depths)
+	}
+	results := finder.getResults()
+	if len(results) != 1 || results[0].value != 2 {
+		t.Fatalf("unexpected results: %v\n", results)
+	}
+}
+
+// Test that links filtered out by -include and -exclude aren't fetched,
+// and that each one is counted as excluded only once.
+func TestURLFilters(t *testing.T) {
+	var mu sync.Mutex
+	fetched := make(map[string]bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mu.Lock()
+		fetched[r.URL.Path] = true
+		mu.Unlock()
+		nav := `<a href="/docs/intro">i</a> <a href="/docs/private/key">p</a>
+			<a href="/blog">b</a> `
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(nav + "homepage"))
+		case "/docs/intro":
+			w.Write([]byte(nav + "introduction"))
+		default:
+			w.Write([]byte("forbidden"))
+		}
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("URL parse failed: %v\n", err)
+	}
+	*minLen = 8
+	*maxLen = 0
+	includes.Set("/docs/*")
+	excludes.Set("re:/private/")
+	defer func() { includes, excludes = nil, nil }()
+	finder, err := newWordFinder([]*url.URL{u}, newFormatter())
+	if err != nil {
+		t.Fatalf("creating finder failed: %v\n", err)
+	}
+	finder.run(context.Background())
+
+	if len(fetched) != 2 || !fetched["/"] || !fetched["/docs/intro"] {
+		t.Fatalf("unexpected pages fetched: %v\n", fetched)
+	}
+	if finder.words.counts["forbidden"] != 0 ||
+		finder.words.counts["introduction"] != 1 {
+		t.Fatalf("unexpected counts: %v\n", finder.words.counts)
+	}
+	if st := finder.getStats(); st.excluded != 2 {
+		t.Fatalf("expected 2 links excluded, got %d\n", st.excluded)
+	}
+}
+
+// Test that robots meta tags, X-Robots-Tag headers, rel="nofollow"
+// and rel="canonical" are honored, unless we're told to ignore them.
+func TestDirectives(t *testing.T) {
+	pages := map[string]string{
+		"/": `<a href="/hidden">hidden</a> <a href="/tagged">tagged</a>
+			<a rel="external nofollow" href="/unfollowed">x</a>
+			<a href="/copy?print=1">copy</a> <a href="/nolinks">n</a>
+			parallelogram`,
+		"/hidden":     `<meta name="robots" content="noindex"> secretword`,
+		"/tagged":     `headerword`,
+		"/unfollowed": `unfollowedword`,
+		"/copy":       `<link rel="canonical" href="/"> parallelogram`,
+		"/nolinks": `<meta name="ROBOTS" content="NOFOLLOW">
+			<a href="/deeper">deeper</a> nolinksword`,
+		"/deeper": `deeperword`,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if r.URL.Path == "/tagged" {
+			w.Header().Add("X-Robots-Tag", "otherbot: nofollow")
+			w.Header().Add("X-Robots-Tag", "site_word_freq: noindex")
+		}
+		if r.URL.Path == "/copy" && r.URL.RawQuery == "" {
+			t.Errorf("canonical page was requested by its own name\n")
+		}
+		w.Write([]byte(pages[r.URL.Path]))
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("URL parse failed: %v\n", err)
+	}
+	*minLen = 5
+	*maxLen = 0
+	crawl := func() map[string]int {
+		finder, err := newWordFinder([]*url.URL{u}, newFormatter())
+		if err != nil {
+			t.Fatalf("creating finder failed: %v\n", err)
+		}
+		finder.run(context.Background())
+		counts := make(map[string]int)
+		for k, v := range finder.words.counts {
+			counts[k] = v
+		}
+		return counts
+	}
+
+	counts := crawl()
+	expected := map[string]int{
+		"parallelogram": 1, "secretword": 0, "headerword": 0,
+		"unfollowedword": 0, "nolinksword": 1, "deeperword": 0,
+	}
+	for k, v := range expected {
+		if counts[k] != v {
+			t.Errorf("expected %d counts of '%s', got %d\n", v, k, counts[k])
+		}
+	}
+
+	*ignoreDirectives = true
+	defer func() { *ignoreDirectives = false }()
+	counts = crawl()
+	for _, k := range []string{"secretword", "headerword",
+		"unfollowedword", "deeperword"} {
+		if counts[k] != 1 {
+			t.Errorf("expected '%s' to be counted, got %d\n", k, counts[k])
+		}
+	}
+	if counts["parallelogram"] != 2 {
+		t.Errorf("expected canonical copy to be counted\n")
+	}
+}
+
+// Test that several start URLs share one crawl, that the scope is the
+// union of their hosts, and that a page reachable from more than one
+// of them is only visited once.  The sites have their own host names,
+// all served by the one test server.
+func TestMultipleSeeds(t *testing.T) {
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		host, port, _ := net.SplitHostPort(r.Host)
+		mu.Lock()
+		hits[host+r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		switch host + r.URL.Path {
+		case "first.test/":
+			w.Write([]byte(`<a href="http://second.test:` + port +
+				`/shared">s</a> <a href="http://second.test:` + port +
+				`/only">o</a> <a href="http://third.test:` + port +
+				`/outside">x</a> firstsite`))
+		case "second.test/":
+			w.Write([]byte(`<a href="/shared">s</a> secondsite`))
+		case "second.test/shared":
+			w.Write([]byte("sharedword"))
+		case "second.test/only":
+			w.Write([]byte("onlyword"))
+		case "third.test/outside":
+			w.Write([]byte("outsideword"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+	_, port, _ := net.SplitHostPort(addr)
+
+	var seeds []*url.URL
+	for _, s := range []string{"first.test", "second.test"} {
+		u, err := url.Parse("http://" + s + ":" + port + "/")
+		if err != nil {
+			t.Fatalf("URL parse failed: %v\n", err)
+		}
+		seeds = append(seeds, u)
+	}
+	*minLen = 5
+	*maxLen = 0
+	crawl := func(seeds []*url.URL) *WordFinder {
+		finder, err := newWordFinder(seeds, newFormatter())
+		if err != nil {
+			t.Fatalf("creating finder failed: %v\n", err)
+		}
+		finder.client.Transport = hostsTransport(addr)
+		finder.robots.client.Transport = finder.client.Transport
+		finder.run(context.Background())
+		return finder
+	}
+
+	finder := crawl(seeds)
+	for _, w := range []string{"firstsite", "secondsite", "sharedword",
+		"onlyword"} {
+		if finder.words.counts[w] != 1 {
+			t.Errorf("expected one count of '%s', got %d\n", w,
+				finder.words.counts[w])
+		}
+	}
+	if hits["second.test/shared"] != 1 || hits["third.test/outside"] != 0 {
+		t.Errorf("unexpected fetches: %v\n", hits)
+	}
+
+	// The second site's pages are only in scope because it is a seed.
+	finder = crawl(seeds[:1])
+	if finder.words.counts["onlyword"] != 0 ||
+		finder.words.counts["firstsite"] != 1 {
+		t.Errorf("followed a link out of the first site's scope\n")
+	}
+}
+
+// Test that transient failures, including a connection dropped while
+// reading the body, are retried, and that pages that keep failing are
+// reported with their attempt counts.
+func TestRetries(t *testing.T) {
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		n := hits[r.URL.Path]
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<a href="/flaky">f</a> <a href="/broken">b</a>
+				<a href="/missing">m</a> <a href="/cutoff">c</a>`))
+		case "/cutoff":
+			if n < 2 {
+				// Promise more than is sent, then hang up.
+				w.Header().Set("Content-Length", "100")
+				w.Write([]byte("truncated "))
+				w.(http.Flusher).Flush()
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			w.Write([]byte("recovered"))
+		case "/flaky":
+			if n < 3 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte("eventually"))
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("URL parse failed: %v\n", err)
+	}
+	*minLen = 5
+	*maxLen = 0
+	*retryBackoff = time.Millisecond
+	defer func() { *retryBackoff = 500 * time.Millisecond }()
+	finder, err := newWordFinder([]*url.URL{u}, newFormatter())
+	if err != nil {
+		t.Fatalf("creating finder failed: %v\n", err)
+	}
+
+	finder.run(context.Background())
+
+	if finder.words.counts["eventually"] != 1 ||
+		finder.words.counts["recovered"] != 1 {
+		t.Fatalf("flaky page was not retried\n")
+	}
+	if finder.words.counts["truncated"] != 0 {
+		t.Fatalf("counted the words of a page that failed to read\n")
+	}
+	errs := make(map[string]int)
+	for _, r := range finder.getErrors() {
+		errs[r.url[len(ts.URL):]] = r.attempt + 1
+	}
+	if len(errs) != 2 || errs["/broken"] != 3 || errs["/missing"] != 1 {
+		t.Fatalf("unexpected errors: %v\n", errs)
+	}
+	st := finder.getStats()
+	if st.retries != 5 {
+		t.Fatalf("expected 5 retries, got %d\n", st.retries)
+	}
+	if st.pages != 5 || len(st.depths) != 2 || st.depths[0]+st.depths[1] != 5 {
+		t.Fatalf("expected 5 pages processed, got %d by depth %v\n",
+			st.pages, st.depths)
+	}
+
+	// A retry that comes due after the -iter limit isn't made, but
+	// its failure is still reported.
+	*iter = 1
+	defer func() { *iter = 0 }()
+	finder, err = newWordFinder([]*url.URL{u}, newFormatter())
+	if err != nil {
+		t.Fatalf("creating finder failed: %v\n", err)
+	}
+	finder.run(context.Background())
+	errs = make(map[string]int)
+	for _, r := range finder.getErrors() {
+		errs[r.url[len(ts.URL):]] = r.attempt + 1
+	}
+	if errs["/broken"] != 1 || finder.getStats().retries != 0 {
+		t.Fatalf("unexpected errors after the limit: %v\n", errs)
+	}
+}
+
+// Test which errors are worth retrying.
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{io.ErrUnexpectedEOF, true},
+		{&url.Error{Op: "Get", URL: "x", Err: &net.DNSError{IsNotFound: true}},
+			false},
+		{&net.DNSError{IsTimeout: true}, true},
+		{&url.Error{Op: "Get", URL: "x", Err: x509.UnknownAuthorityError{}},
+			false},
+		{&tls.CertificateVerificationError{}, false},
+		{errors.New(`unsupported protocol scheme "ftp"`), false},
+		{syscall.ECONNRESET, true},
+	}
+	for _, tc := range tests {
+		if isTransient(tc.err) != tc.transient {
+			t.Errorf("%v: expected transient %t\n", tc.err, tc.transient)
+		}
+	}
+}
+
+// Test the delays between retries, which grow to at most maxBackoff,
+// or are zero when there is no backoff.
+func TestRetryDelay(t *testing.T) {
+	defer func() { *retryBackoff = 500 * time.Millisecond }()
+	tests := []struct {
+		backoff  time.Duration
+		attempt  int
+		min, max time.Duration
+	}{
+		{0, 1, 0, 0},
+		{0, 10, 0, 0},
+		{time.Second, 1, 500 * time.Millisecond, time.Second},
+		{time.Second, 3, 2 * time.Second, 4 * time.Second},
+		{time.Second, 20, maxBackoff / 2, maxBackoff},
+		{time.Second, 70, maxBackoff / 2, maxBackoff},
+		{time.Hour, 1, maxBackoff / 2, maxBackoff},
+	}
+	for _, tc := range tests {
+		*retryBackoff = tc.backoff
+		if d := retryDelay(tc.attempt); d < tc.min || d > tc.max {
+			t.Errorf("backoff %v attempt %d: delay %v not in [%v, %v]\n",
+				tc.backoff, tc.attempt, d, tc.min, tc.max)
+		}
+	}
+}
+
 // Test locating unicode.
 func TestConvertUnicode(t *testing.T) {
 	b := []byte{'A', '\\', 'u', '0', '0', '2', '2', 'H',
@@ -0,0 +1,152 @@
+// The sitemap code finds a site's sitemaps, either from robots.txt or
+// at the conventional /sitemap.xml location, and collects the page
+// URLs they list so that they can seed the crawl.  Sitemap indexes
+// are followed, and gzipped sitemaps are handled.
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// Limits from the sitemaps protocol, which we also use to
+	// keep a broken or hostile site from running us out of memory.
+	maxSitemapSize = 50 * 1024 * 1024
+	maxSitemaps    = 1000
+)
+
+// The parts of a sitemap or sitemap index we need.  Both list
+// their URLs in <loc> elements.
+type sitemapDoc struct {
+	XMLName  xml.Name
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// Returns the page URLs listed in the start sites' sitemaps, after
+// checking they are in scope and canonicalizing them.  Sitemap indexes
+// are processed iteratively with a queue, so a deeply nested (or
+// looping) set of indexes can't get us into trouble.  The sitemaps an
+// index lists must be in scope and allowed by robots.txt too, so that
+// a hostile index can't send us to other hosts.
+func (wf *WordFinder) sitemapURLs(ctx context.Context) []string {
+	var queue []string
+	hosts := make(map[string]bool)
+	for _, start := range wf.startURLs {
+		key := robotsKey(start)
+		if hosts[key] {
+			continue
+		}
+		hosts[key] = true
+		var found []string
+		if !*ignoreRobots {
+			found = wf.robots.rulesFor(ctx, start).sitemaps
+		}
+		if len(found) == 0 {
+			found = []string{key + "/sitemap.xml"}
+		}
+		queue = append(queue, found...)
+	}
+
+	var pages []string
+	seen := make(map[string]bool)
+	for n := 0; len(queue) > 0 && n < maxSitemaps; n++ {
+		sm := queue[0]
+		queue = queue[1:]
+		if seen[sm] {
+			continue
+		}
+		seen[sm] = true
+
+		wf.fmtr.showStatusLine(sm, wf.interrupt)
+		doc, err := wf.fetchSitemap(ctx, sm)
+		if err != nil {
+			if !isCancel(err) {
+				log.Printf("error reading sitemap '%s': %v\n", sm, err)
+				wf.mu.Lock()
+				wf.errRecs = append(wf.errRecs, searchRecord{url: sm, err: err})
+				wf.mu.Unlock()
+			}
+			continue
+		}
+		for _, s := range doc.Sitemaps {
+			loc := strings.TrimSpace(s.Loc)
+			u, err := url.Parse(loc)
+			if err != nil || !u.IsAbs() || !wf.scope.containsURL(u) ||
+				!wf.allowed(ctx, loc) {
+				continue
+			}
+			queue = append(queue, loc)
+		}
+		for _, p := range doc.URLs {
+			u, err := url.Parse(strings.TrimSpace(p.Loc))
+			if err != nil || !u.IsAbs() || !wf.scope.containsURL(u) {
+				continue
+			}
+			pages = append(pages, wf.canon.canonical(u))
+		}
+	}
+	return pages
+}
+
+// Fetches and decodes one sitemap or sitemap index, which may be
+// gzipped.  We check for the gzip magic number rather than trusting
+// the file name or content type, as sites are careless about both.
+func (wf *WordFinder) fetchSitemap(ctx context.Context,
+	sm string) (*sitemapDoc, error) {
+	req, err := http.NewRequest(http.MethodGet, sm, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", *userAgent)
+	if err := wf.waitTurn(ctx, req.URL); err != nil {
+		return nil, err
+	}
+	resp, err := wf.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	wf.checkOverload(resp)
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("HTTP status %d : %s", resp.StatusCode,
+			http.StatusText(resp.StatusCode))
+	}
+
+	var r io.Reader = bufio.NewReader(io.LimitReader(resp.Body,
+		maxSitemapSize))
+	if magic, _ := r.(*bufio.Reader).Peek(2); len(magic) == 2 &&
+		magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = io.LimitReader(gz, maxSitemapSize)
+	}
+
+	var doc sitemapDoc
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	switch doc.XMLName.Local {
+	case "urlset", "sitemapindex":
+		return &doc, nil
+	}
+	return nil, fmt.Errorf("not a sitemap: root element is <%s>",
+		doc.XMLName.Local)
+}
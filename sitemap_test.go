@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Test discovering sitemaps from robots.txt, following an index to a
+// gzipped sitemap, and crawling only the sitemap pages.  Sitemaps
+// listed in the index that are out of scope or disallowed aren't
+// fetched, and only the pages queued are counted.
+func TestSitemap(t *testing.T) {
+	var ts *httptest.Server
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		fill := func(s string) []byte {
+			return []byte(strings.ReplaceAll(s, "SERVER", ts.URL))
+		}
+		switch r.URL.Path {
+		case "/robots.txt":
+			w.Write(fill("User-agent: *\nDisallow: /private/\n" +
+				"Sitemap: SERVER/index.xml\n"))
+		case "/index.xml":
+			w.Write(fill(`<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>SERVER/pages.xml</loc></sitemap>
+  <sitemap><loc>SERVER/more.xml.gz</loc></sitemap>
+  <sitemap><loc>SERVER/index.xml</loc></sitemap>
+  <sitemap><loc>SERVER/private/map.xml</loc></sitemap>
+  <sitemap><loc>http://elsewhere.invalid/map.xml</loc></sitemap>
+</sitemapindex>`))
+		case "/pages.xml":
+			w.Write(fill(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc> SERVER/listed?utm_source=map </loc></url>
+  <url><loc>SERVER/listed</loc></url>
+  <url><loc>SERVER/</loc></url>
+</urlset>`))
+		case "/more.xml.gz":
+			var gz bytes.Buffer
+			zw := gzip.NewWriter(&gz)
+			zw.Write(fill(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>SERVER/orphan</loc></url>
+  <url><loc>http://elsewhere.example.com/page</loc></url>
+</urlset>`))
+			zw.Close()
+			w.Header().Set("Content-Type", "application/x-gzip")
+			w.Write(gz.Bytes())
+		case "/":
+			w.Write([]byte(`<a href="/linked">linked</a> homepageword`))
+		case "/listed":
+			w.Write([]byte(`<a href="/linked">linked</a> listedword`))
+		case "/orphan":
+			w.Write([]byte(`orphanword`))
+		case "/linked":
+			w.Write([]byte(`linkedword`))
+		}
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("URL parse failed: %v\n", err)
+	}
+	*minLen = 6
+	*maxLen = 0
+	finder, err := newWordFinder([]*url.URL{u}, newFormatter())
+	if err != nil {
+		t.Fatalf("creating finder failed: %v\n", err)
+	}
+	pages := finder.sitemapURLs(context.Background())
+	sort.Strings(pages)
+	if strings.Join(pages, " ") != strings.Join([]string{ts.URL + "/",
+		ts.URL + "/listed", ts.URL + "/listed", ts.URL + "/orphan"}, " ") {
+		t.Fatalf("unexpected sitemap pages: %v\n", pages)
+	}
+	if hits["/private/map.xml"] != 0 || len(finder.getErrors()) != 0 {
+		t.Fatalf("fetched a disallowed or out of scope sitemap\n")
+	}
+
+	// Now crawl without following links.
+	*sitemap = true
+	*followLinks = false
+	defer func() { *sitemap, *followLinks = false, true }()
+	finder, _ = newWordFinder([]*url.URL{u}, newFormatter())
+	finder.run(context.Background())
+	for _, w := range []string{"homepageword", "listedword", "orphanword"} {
+		if finder.words.counts[w] != 1 {
+			t.Errorf("expected '%s' to be counted\n", w)
+		}
+	}
+	if finder.words.counts["linkedword"] != 0 {
+		t.Errorf("link was followed\n")
+	}
+	if finder.getStats().sitemap != 2 {
+		t.Errorf("unexpected sitemap count: %d\n", finder.getStats().sitemap)
+	}
+}
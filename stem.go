@@ -0,0 +1,318 @@
+// Stemming reduces the inflected forms of a word to a common stem, so
+// that "crawl", "crawls", "crawled" and "crawling" are counted as one
+// word.  The English stemmer is the Porter2 algorithm from the Snowball
+// project, described at
+// https://snowballstem.org/algorithms/english/stemmer.html
+// Stems often aren't words themselves ("happili"), so the report shows
+// the most common form that was counted under each stem.
+package main
+
+import "strings"
+
+// The stemmers, keyed by language code.  Each takes a lower case word.
+var stemmers = map[string]func(string) string{
+	"en": stemEnglish,
+}
+
+// Words that the English algorithm would get wrong, and their stems.
+var englishExceptions = map[string]string{
+	"skis": "ski", "skies": "sky", "dying": "die", "lying": "lie",
+	"tying": "tie", "idly": "idl", "gently": "gentl", "ugly": "ugli",
+	"early": "earli", "only": "onli", "singly": "singl", "sky": "sky",
+	"news": "news", "howe": "howe", "atlas": "atlas", "cosmos": "cosmos",
+	"bias": "bias", "andes": "andes",
+}
+
+// Words left alone once step 1a has removed any plural.
+var englishExceptions1a = map[string]bool{
+	"inning": true, "outing": true, "canning": true, "herring": true,
+	"earring": true, "proceed": true, "exceed": true, "succeed": true,
+}
+
+// A suffix rule replaces the suffix, if the condition holds for the
+// word with the suffix removed.
+type suffixRule struct {
+	suffix string
+	repl   string
+	cond   func(p *porter, stem int) bool
+}
+
+// Conditions for the suffix rules, given where the suffix starts.
+func inR1(p *porter, stem int) bool { return stem >= p.r1 }
+func inR2(p *porter, stem int) bool { return stem >= p.r2 }
+
+func afterL(p *porter, stem int) bool {
+	return inR1(p, stem) && stem > 0 && p.b[stem-1] == 'l'
+}
+
+func afterLiEnding(p *porter, stem int) bool {
+	return inR1(p, stem) && stem > 0 &&
+		strings.IndexByte("cdeghkmnrt", p.b[stem-1]) >= 0
+}
+
+func afterST(p *porter, stem int) bool {
+	return inR2(p, stem) && stem > 0 &&
+		(p.b[stem-1] == 's' || p.b[stem-1] == 't')
+}
+
+var step2Rules = []suffixRule{
+	{"tional", "tion", inR1}, {"enci", "ence", inR1},
+	{"anci", "ance", inR1}, {"abli", "able", inR1},
+	{"entli", "ent", inR1}, {"izer", "ize", inR1},
+	{"ization", "ize", inR1}, {"ational", "ate", inR1},
+	{"ation", "ate", inR1}, {"ator", "ate", inR1},
+	{"alism", "al", inR1}, {"aliti", "al", inR1}, {"alli", "al", inR1},
+	{"fulness", "ful", inR1}, {"ousli", "ous", inR1},
+	{"ousness", "ous", inR1}, {"iveness", "ive", inR1},
+	{"iviti", "ive", inR1}, {"biliti", "ble", inR1},
+	{"bli", "ble", inR1}, {"ogi", "og", afterL},
+	{"fulli", "ful", inR1}, {"lessli", "less", inR1},
+	{"li", "", afterLiEnding},
+}
+
+var step3Rules = []suffixRule{
+	{"tional", "tion", inR1}, {"ational", "ate", inR1},
+	{"alize", "al", inR1}, {"icate", "ic", inR1}, {"iciti", "ic", inR1},
+	{"ical", "ic", inR1}, {"ful", "", inR1}, {"ness", "", inR1},
+	{"ative", "", inR2},
+}
+
+var step4Rules = []suffixRule{
+	{"al", "", inR2}, {"ance", "", inR2}, {"ence", "", inR2},
+	{"er", "", inR2}, {"ic", "", inR2}, {"able", "", inR2},
+	{"ible", "", inR2}, {"ant", "", inR2}, {"ement", "", inR2},
+	{"ment", "", inR2}, {"ent", "", inR2}, {"ism", "", inR2},
+	{"ate", "", inR2}, {"iti", "", inR2}, {"ous", "", inR2},
+	{"ive", "", inR2}, {"ize", "", inR2}, {"ion", "", afterST},
+}
+
+// The porter holds a word as it is being stemmed, along with the
+// starts of its R1 and R2 regions.
+type porter struct {
+	b      []byte
+	r1, r2 int
+}
+
+// The curly quotes that Snowball treats as apostrophes.
+var apostrophes = strings.NewReplacer("\u2019", "'", "\u2018", "'",
+	"\u201b", "'")
+
+// Returns the English stem of a lower case word.  Curly apostrophes
+// are made straight first, and words with other letters outside ASCII
+// aren't English, and are returned as they are.
+func stemEnglish(word string) string {
+	word = apostrophes.Replace(word)
+	for i := 0; i < len(word); i++ {
+		if word[i] >= 0x80 {
+			return word
+		}
+	}
+	word = strings.TrimPrefix(word, "'")
+	if len(word) <= 2 {
+		return word
+	}
+	if s, ok := englishExceptions[word]; ok {
+		return s
+	}
+
+	p := &porter{b: []byte(word)}
+	p.markY()
+	p.regions()
+	p.step0()
+	p.step1a()
+	if englishExceptions1a[string(p.b)] {
+		return string(p.b)
+	}
+	p.step1b()
+	p.step1c()
+	p.apply(step2Rules)
+	p.apply(step3Rules)
+	p.apply(step4Rules)
+	p.step5()
+	return strings.ReplaceAll(string(p.b), "Y", "y")
+}
+
+// Reports whether the character is a vowel.  A 'y' that is acting as
+// a consonant has been marked as 'Y'.
+func isVowel(c byte) bool {
+	switch c {
+	case 'a', 'e', 'i', 'o', 'u', 'y':
+		return true
+	}
+	return false
+}
+
+// Marks an initial 'y', and a 'y' after a vowel, as a consonant.
+func (p *porter) markY() {
+	for i, c := range p.b {
+		if c == 'y' && (i == 0 || isVowel(p.b[i-1])) {
+			p.b[i] = 'Y'
+		}
+	}
+}
+
+// Finds R1, the part of the word after the first non-vowel following
+// a vowel, and R2, the same within R1.
+func (p *porter) regions() {
+	p.r1 = p.region(0)
+	for _, prefix := range []string{"gener", "commun", "arsen"} {
+		if strings.HasPrefix(string(p.b), prefix) {
+			p.r1 = len(prefix)
+		}
+	}
+	p.r2 = p.region(p.r1)
+}
+
+func (p *porter) region(start int) int {
+	for i := start + 1; i < len(p.b); i++ {
+		if !isVowel(p.b[i]) && isVowel(p.b[i-1]) {
+			return i + 1
+		}
+	}
+	return len(p.b)
+}
+
+func (p *porter) hasSuffix(s string) bool {
+	return strings.HasSuffix(string(p.b), s)
+}
+
+// Returns the longest of the suffixes that the word ends with, or ""
+// if it ends with none of them.
+func (p *porter) longest(suffixes ...string) string {
+	best := ""
+	for _, s := range suffixes {
+		if len(s) > len(best) && p.hasSuffix(s) {
+			best = s
+		}
+	}
+	return best
+}
+
+// Replaces the last n characters of the word.
+func (p *porter) replace(n int, repl string) {
+	p.b = append(p.b[:len(p.b)-n], repl...)
+}
+
+// Reports whether the word up to end contains a vowel.
+func (p *porter) hasVowel(end int) bool {
+	for _, c := range p.b[:end] {
+		if isVowel(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the word up to end ends in a short syllable: a
+// vowel followed by a non-vowel other than 'w', 'x' or 'Y' and
+// preceded by a non-vowel, or a vowel at the start of the word
+// followed by a non-vowel.
+func (p *porter) shortSyllable(end int) bool {
+	b := p.b[:end]
+	switch {
+	case len(b) == 2:
+		return isVowel(b[0]) && !isVowel(b[1])
+	case len(b) > 2:
+		c := b[len(b)-1]
+		return !isVowel(b[len(b)-3]) && isVowel(b[len(b)-2]) &&
+			!isVowel(c) && c != 'w' && c != 'x' && c != 'Y'
+	}
+	return false
+}
+
+// Applies the rule for the longest matching suffix, if its condition
+// holds.  Shorter suffixes aren't tried if it doesn't.
+func (p *porter) apply(rules []suffixRule) {
+	var match *suffixRule
+	for i, r := range rules {
+		if (match == nil || len(r.suffix) > len(match.suffix)) &&
+			p.hasSuffix(r.suffix) {
+			match = &rules[i]
+		}
+	}
+	if match == nil {
+		return
+	}
+	stem := len(p.b) - len(match.suffix)
+	if match.cond(p, stem) {
+		p.replace(len(match.suffix), match.repl)
+	}
+}
+
+// Removes possessives.
+func (p *porter) step0() {
+	if s := p.longest("'", "'s", "'s'"); s != "" {
+		p.replace(len(s), "")
+	}
+}
+
+// Removes plurals.
+func (p *porter) step1a() {
+	switch s := p.longest("sses", "ied", "ies", "us", "ss", "s"); s {
+	case "sses":
+		p.replace(2, "")
+	case "ied", "ies":
+		if len(p.b) > 4 {
+			p.replace(3, "i")
+		} else {
+			p.replace(3, "ie")
+		}
+	case "s":
+		if p.hasVowel(len(p.b) - 2) {
+			p.replace(1, "")
+		}
+	}
+}
+
+// Removes past tenses and participles, tidying up what is left.
+func (p *porter) step1b() {
+	s := p.longest("eed", "eedly", "ed", "edly", "ing", "ingly")
+	switch s {
+	case "":
+		return
+	case "eed", "eedly":
+		if len(p.b)-len(s) >= p.r1 {
+			p.replace(len(s), "ee")
+		}
+		return
+	}
+	if !p.hasVowel(len(p.b) - len(s)) {
+		return
+	}
+	p.replace(len(s), "")
+	switch {
+	case p.hasSuffix("at") || p.hasSuffix("bl") || p.hasSuffix("iz"):
+		p.replace(0, "e")
+	case p.longest("bb", "dd", "ff", "gg", "mm", "nn", "pp", "rr",
+		"tt") != "":
+		p.replace(1, "")
+	case p.r1 >= len(p.b) && p.shortSyllable(len(p.b)):
+		p.replace(0, "e")
+	}
+}
+
+// Turns a final 'y' after a non-vowel into 'i'.
+func (p *porter) step1c() {
+	n := len(p.b)
+	if n > 2 && (p.b[n-1] == 'y' || p.b[n-1] == 'Y') && !isVowel(p.b[n-2]) {
+		p.b[n-1] = 'i'
+	}
+}
+
+// Removes a final 'e', or the second of a final "ll".
+func (p *porter) step5() {
+	n := len(p.b)
+	if n == 0 {
+		return
+	}
+	switch p.b[n-1] {
+	case 'e':
+		if n-1 >= p.r2 || (n-1 >= p.r1 && !p.shortSyllable(n-1)) {
+			p.replace(1, "")
+		}
+	case 'l':
+		if n-1 >= p.r2 && p.b[n-2] == 'l' {
+			p.replace(1, "")
+		}
+	}
+}
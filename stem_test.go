@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+// Test the English stemmer against the output of the reference
+// implementation.
+func TestStemEnglish(t *testing.T) {
+	tests := map[string]string{
+		"crawl": "crawl", "crawls": "crawl", "crawled": "crawl",
+		"crawling": "crawl", "consign": "consign", "consigned": "consign",
+		"consignment": "consign", "consistency": "consist",
+		"consistently": "consist", "consolation": "consol",
+		"consolatory": "consolatori", "consolidate": "consolid",
+		"consolingly": "consol", "conspicuously": "conspicu",
+		"conspiracy": "conspiraci", "conspirators": "conspir",
+		"constable": "constabl", "constancy": "constanc",
+		"generate": "generat", "generously": "generous",
+		"communism": "communism", "running": "run", "hopping": "hop",
+		"hoping": "hope", "agreed": "agre", "feed": "feed",
+		"fizzed": "fizz", "caress": "caress", "ponies": "poni",
+		"ties": "tie", "cats": "cat", "gaps": "gap", "kiwis": "kiwi",
+		"happily": "happili", "skies": "sky", "dying": "die",
+		"news": "news", "succeeded": "succeed", "exceeds": "exceed",
+		"sayings": "say", "youth": "youth", "controlling": "control",
+		"nationalization": "nation", "hopefulness": "hope",
+		"electrical": "electr", "adjustable": "adjust",
+		"effective": "effect", "formality": "formal",
+		"possibly": "possibl", "analogies": "analog", "rolled": "roll",
+		"an": "an", "café": "café",
+	}
+	for word, expected := range tests {
+		if got := stemEnglish(word); got != expected {
+			t.Errorf("stem of '%s': expected '%s', got '%s'\n", word,
+				expected, got)
+		}
+	}
+}
+
+// Test that inflected forms are counted under one stem, and reported
+// as their most common form.
+func TestStemCounts(t *testing.T) {
+	defer func() { *stemLang = "" }()
+	*stemLang = "en"
+	*minLen = 4
+	*maxLen = 0
+
+	wds := newWordCounts(0, false)
+	scanText("Crawling crawls crawling crawled crawl crawling", wds, 1)
+	if wds.counts["crawl"] != 6 {
+		t.Fatalf("unexpected counts: %v\n", wds.counts)
+	}
+	if f := wds.form("crawl"); f != "crawling" {
+		t.Fatalf("expected form 'crawling', got '%s'\n", f)
+	}
+
+	// Curly apostrophes are treated as straight ones.
+	wds = newWordCounts(0, false)
+	scanText("crawler's crawlers crawler’s", wds, 1)
+	if wds.counts["crawler"] != 3 {
+		t.Fatalf("unexpected counts: %v\n", wds.counts)
+	}
+}
@@ -0,0 +1,191 @@
+// Stopwords are the common function words, such as "which" and "their",
+// that would otherwise crowd the top of the histogram.  There are
+// built-in lists for several languages, based on the Snowball project's
+// lists, and more can be loaded from a file.  Stopwords are dropped in
+// scanText, so they never enter the per-page counts.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The built-in stopword lists, keyed by ISO 639-1 language code.
+var stopwordLists = map[string]string{
+	"en": `a about above after again against all am an and any are as at be
+		because been before being below between both but by can could did do
+		does doing down during each few for from further had has have having
+		he her here hers herself him himself his how i if in into is it its
+		itself just me might more most must my myself no nor not now of off
+		on once only or other ought our ours ourselves out over own same
+		shall she should so some such than that the their theirs them
+		themselves then there these they this those through to too under
+		until up upon very was we were what when where which while who whom
+		whose why will with within without would you your yours yourself
+		yourselves also another anything around became become becomes
+		either else every everything however indeed many much neither
+		never often perhaps quite rather since something still though
+		together toward towards whether yet`,
+
+	"de": `aber alle allem allen aller alles als also am an ander andere
+		anderem anderen anderer anderes anderm andern anderr anders auch auf
+		aus bei bin bis bist da damit dann das dass dasselbe dazu dein deine
+		deinem deinen deiner deines dem demselben den denn denselben der
+		derer derselbe derselben des desselben dessen dich die dies diese
+		dieselbe dieselben diesem diesen dieser dieses dir doch dort du durch
+		ein eine einem einen einer eines einig einige einigem einigen einiger
+		einiges einmal er es etwas euch euer eure eurem euren eurer eures
+		für gegen gewesen hab habe haben hat hatte hatten hier hin hinter ich
+		ihm ihn ihnen ihr ihre ihrem ihren ihrer ihres im in indem ins ist
+		jede jedem jeden jeder jedes jene jenem jenen jener jenes jetzt kann
+		kein keine keinem keinen keiner keines können könnte machen man
+		manche manchem manchen mancher manches mein meine meinem meinen
+		meiner meines mich mir mit muss musste nach nicht nichts noch nun nur
+		ob oder ohne sehr sein seine seinem seinen seiner seines selbst sich
+		sie sind so solche solchem solchen solcher solches soll sollte
+		sondern sonst über um und uns unsere unserem unseren unserer unseres
+		unter viel vom von vor während war waren warst was weg weil weiter
+		welche welchem welchen welcher welches wenn werde werden wie wieder
+		will wir wird wirst wo wollen wollte würde würden zu zum zur zwar
+		zwischen`,
+
+	"fr": `au aux avec ce ces dans de des du elle en et eux il ils je la le
+		les leur leurs lui ma mais me même mes moi mon ne nos notre nous on
+		ou par pas pour qu que qui sa se ses son sur ta te tes toi ton tu un
+		une vos votre vous c d j l à m n s t y été étée étées étés étant
+		étante étants étantes suis es est sommes êtes sont serai seras sera
+		serons serez seront serais serait serions seriez seraient étais
+		était étions étiez étaient fus fut fûmes fûtes furent sois soit
+		soyons soyez soient fusse fusses fût fussions fussiez fussent ayant
+		ayante ayantes ayants eu eue eues eus ai as avons avez ont aurai
+		auras aura aurons aurez auront aurais aurait aurions auriez auraient
+		avais avait avions aviez avaient eut eûmes eûtes eurent aie aies ait
+		ayons ayez aient eusse eusses eût eussions eussiez eussent ceci cela
+		celà cet cette ici ils les leurs quel quels quelle quelles sans soi
+		aussi alors autre autres avant après bien comme comment donc encore
+		entre peut plus tous tout toute toutes très`,
+
+	"es": `de la que el en y a los del se las por un para con no una su al lo
+		como más pero sus le ya o este sí porque esta entre cuando muy sin
+		sobre también me hasta hay donde quien desde todo nos durante todos
+		uno les ni contra otros ese eso ante ellos e esto mí antes algunos
+		qué unos yo otro otras otra él tanto esa estos mucho quienes nada
+		muchos cual poco ella estar estas algunas algo nosotros mi mis tú te
+		ti tu tus ellas nosotras vosotros vosotras os mío mía míos mías tuyo
+		tuya tuyos tuyas suyo suya suyos suyas nuestro nuestra nuestros
+		nuestras vuestro vuestra vuestros vuestras esos esas estoy estás
+		está estamos estáis están esté estés estemos estéis estén estaba
+		estabas estábamos estaban estuvo estuvieron he has ha hemos habéis
+		han haya hayan había habían hubo ser soy eres es somos sois son sea
+		sean era eras éramos eran fue fueron siendo sido tengo tienes tiene
+		tenemos tenéis tienen tenía tenían tuvo tener cada donde mientras
+		según siempre todas toda`,
+
+	"it": `ad al allo ai agli all agl alla alle con col coi da dal dallo dai
+		dagli dall dagl dalla dalle di del dello dei degli dell degl della
+		delle in nel nello nei negli nell negl nella nelle su sul sullo sui
+		sugli sull sugl sulla sulle per tra contro io tu lui lei noi voi
+		loro mio mia miei mie tuo tua tuoi tue suo sua suoi sue nostro
+		nostra nostri nostre vostro vostra vostri vostre mi ti ci vi lo la
+		li le gli ne il un uno una ma ed se perché anche come dov dove che
+		chi cui non più quale quanto quanti quanta quante quello quelli
+		quella quelle questo questi questa queste si tutto tutti a c e i l
+		o ho hai ha abbiamo avete hanno abbia abbiano avevo aveva avevamo
+		avevano ebbe sono sei è siamo siete sia siano ero era eravamo erano
+		fui fu furono essere stato stata stati state fare fatto molto poi
+		ancora allora sempre però mentre dopo prima`,
+
+	"pt": `a à ao aos aquela aquelas aquele aqueles aquilo as às até com como
+		da das de dela delas dele deles depois do dos e é ela elas ele eles
+		em entre era eram essa essas esse esses esta estas este estes eu foi
+		fomos for foram fosse fossem há isso isto já lhe lhes mais mas me
+		mesmo meu meus minha minhas muito na não nas nem no nos nós nossa
+		nossas nosso nossos num numa o os ou para pela pelas pelo pelos por
+		qual quando que quem são se seja sejam sem será seu seus só sua suas
+		também te tem têm tinha tinham tu tua tuas teu teus um uma você
+		vocês vos está estão estava estavam esteve estiveram ser sendo sido
+		ter tendo tido havia haviam houve ainda onde sobre sempre cada
+		porque pois então`,
+
+	"nl": `aan al alles als altijd andere ben bij daar dan dat de der deze die
+		dit doch doen door dus een eens en er ge geen geweest haar had heb
+		hebben heeft hem het hier hij hoe hun iemand iets ik in is ja je kan
+		kon kunnen maar me meer men met mij mijn moet na naar niet niets nog
+		nu of om omdat onder ons ook op over reeds te tegen toch toen tot u
+		uit uw van veel voor want waren was wat werd wezen wie wil worden
+		wordt zal ze zelf zich zij zijn zo zonder zou zeer welke waar
+		tussen worden werden hadden zouden`,
+}
+
+// The stopwords in effect, set up from the command line flags.
+var stopwords map[string]bool
+
+// Reports whether a word is a stopword.  The lists are in lower case.
+func isStopword(word string) bool {
+	return len(stopwords) > 0 && stopwords[strings.ToLower(word)]
+}
+
+// The stopwords that a phrase may not start or end with.  These are
+// the English stopwords unless -lang chooses others, so that "of the"
+// isn't counted as a phrase even when stopwords are counted as words.
+var phraseStopwords map[string]bool
+
+// Reports whether a word may not start or end a phrase.
+func isPhraseStopword(word string) bool {
+	return len(phraseStopwords) > 0 && phraseStopwords[strings.ToLower(word)]
+}
+
+// Builds the stopword set from a comma-separated list of language codes,
+// or "none", and an optional file of extra words.  The file holds words
+// separated by white space, and lines starting with '#' are skipped.
+func loadStopwords(langs, path string) (map[string]bool, error) {
+	set := make(map[string]bool)
+	for _, lang := range strings.Split(langs, ",") {
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if lang == "" || lang == "none" {
+			continue
+		}
+		list, ok := stopwordLists[lang]
+		if !ok {
+			return nil, fmt.Errorf("no stopword list for language '%s' "+
+				"(have %s)", lang, strings.Join(stopwordLangs(), ", "))
+		}
+		for _, w := range strings.Fields(list) {
+			set[w] = true
+		}
+	}
+	if path == "" {
+		return set, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, w := range strings.Fields(line) {
+			set[strings.ToLower(w)] = true
+		}
+	}
+	return set, sc.Err()
+}
+
+// Returns the codes of the built-in stopword lists, sorted.
+func stopwordLangs() []string {
+	var langs []string
+	for l := range stopwordLists {
+		langs = append(langs, l)
+	}
+	sort.Strings(langs)
+	return langs
+}
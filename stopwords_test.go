@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test that stopwords are dropped and counted, whatever their case.
+func TestStopwords(t *testing.T) {
+	defer func() { stopwords = nil }()
+	*minLen = 4
+	*maxLen = 0
+
+	path := filepath.Join(t.TempDir(), "stopwords")
+	err := os.WriteFile(path, []byte("# site boilerplate\nCookie   privacy\n"),
+		0644)
+	if err != nil {
+		t.Fatalf("writing stopwords failed: %v\n", err)
+	}
+	stopwords, err = loadStopwords("en, DE", path)
+	if err != nil {
+		t.Fatalf("loading stopwords failed: %v\n", err)
+	}
+
+	wds := newWordCounts(0, false)
+	scanText("Which of their cookie settings? Über diese privacy Seite, "+
+		"about which nothing", wds, 1)
+	if wds.stopped != 8 {
+		t.Fatalf("expected 8 stopwords dropped, got %d\n", wds.stopped)
+	}
+	for _, w := range []string{"settings", "Seite", "nothing"} {
+		if wds.counts[w] != 1 {
+			t.Errorf("expected '%s' to be counted\n", w)
+		}
+	}
+	if len(wds.counts) != 3 {
+		t.Errorf("unexpected words counted: %v\n", wds.counts)
+	}
+
+	if set, err := loadStopwords("none", ""); err != nil || len(set) != 0 {
+		t.Fatalf("expected no stopwords for 'none', got %d, %v\n",
+			len(set), err)
+	}
+	if _, err := loadStopwords("en,xx", ""); err == nil {
+		t.Fatalf("expected error for unknown language\n")
+	}
+}
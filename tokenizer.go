@@ -0,0 +1,179 @@
+// A Tokenizer splits text into words.  The default is a hand-written
+// scanner over the Unicode categories, which is several times faster
+// than the original regular expression, and understands a little more
+// about how words are written: "don't", "e-mail" and "C++" are each one
+// word.  The regular expression is kept as an option, for comparison.
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A span is the location of a word in a text, as byte offsets.
+type span struct {
+	start, end int
+}
+
+// The Tokenizer interface finds the words in a text.
+type Tokenizer interface {
+	// Appends the locations of the words in the text to spans, and
+	// returns the result.
+	Tokens(text string, spans []span) []span
+}
+
+// The tokenizer in effect, set up from the command line flags.
+var tokenizer Tokenizer = &unicodeTokenizer{joinHyphens: true}
+
+// Creates the tokenizer of the given kind, which applies the given
+// policy for words with digits.
+func newTokenizer(kind string, joinHyphens bool,
+	digits string) (Tokenizer, error) {
+	var t Tokenizer
+	switch kind {
+	case "unicode":
+		t = &unicodeTokenizer{joinHyphens: joinHyphens}
+	case "regexp":
+		t = &regexpTokenizer{re: words}
+	default:
+		return nil, fmt.Errorf("unknown tokenizer '%s'", kind)
+	}
+	switch digits {
+	case "any":
+		return t, nil
+	case "mixed", "none":
+		return &digitFilter{t, digits == "none"}, nil
+	}
+	return nil, fmt.Errorf("unknown digits policy '%s'", digits)
+}
+
+// The regexpTokenizer takes each match of a regular expression as a
+// word.
+type regexpTokenizer struct {
+	re *regexp.Regexp
+}
+
+func (rt *regexpTokenizer) Tokens(text string, spans []span) []span {
+	for _, loc := range rt.re.FindAllStringIndex(text, -1) {
+		spans = append(spans, span{loc[0], loc[1]})
+	}
+	return spans
+}
+
+// The unicodeTokenizer takes runs of letters, marks, digits and
+// underscores as words.  An apostrophe between two of these is part
+// of the word, as is a hyphen if joinHyphens is set, and so are one or
+// two trailing '+' or '#' characters, as in "C++" or "C#".
+type unicodeTokenizer struct {
+	joinHyphens bool
+}
+
+func (ut *unicodeTokenizer) Tokens(text string, spans []span) []span {
+	start := -1
+	for i := 0; i < len(text); {
+		r, size := decodeRune(text, i)
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			i += size
+			continue
+		}
+		if start < 0 {
+			i += size
+			continue
+		}
+
+		// The word may carry on after a joining character.
+		if ut.joins(r) && i+size < len(text) {
+			if next, _ := decodeRune(text, i+size); isWordRune(next) {
+				i += size
+				continue
+			}
+		}
+		end := i + symbolSuffix(text[i:])
+		spans = append(spans, span{start, end})
+		start = -1
+		if end > i {
+			i = end
+		} else {
+			i += size
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, span{start, len(text)})
+	}
+	return spans
+}
+
+// Reports whether the character joins the characters either side of
+// it into one word.
+func (ut *unicodeTokenizer) joins(r rune) bool {
+	switch r {
+	case '\'', '’':
+		return true
+	case '-', '‐':
+		return ut.joinHyphens
+	}
+	return false
+}
+
+// Returns the length of a run of one or two '+' or '#' characters at
+// the start of the text, if they end a word, as in "C++", or 0.
+func symbolSuffix(text string) int {
+	n := 0
+	for n < len(text) && n < 2 && (text[n] == '+' || text[n] == '#') {
+		n++
+	}
+	if n == 0 || n < len(text) && (text[n] == '+' || text[n] == '#') {
+		return 0
+	}
+	if n < len(text) {
+		if r, _ := decodeRune(text, n); isWordRune(r) {
+			return 0
+		}
+	}
+	return n
+}
+
+// Decodes the character at byte offset i, with a fast path for ASCII.
+func decodeRune(text string, i int) (rune, int) {
+	if c := text[i]; c < utf8.RuneSelf {
+		return rune(c), 1
+	}
+	return utf8.DecodeRuneInString(text[i:])
+}
+
+// Reports whether the character can be part of a word.
+func isWordRune(r rune) bool {
+	if r < utf8.RuneSelf {
+		return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' ||
+			'0' <= r && r <= '9' || r == '_'
+	}
+	return unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r)
+}
+
+// The digitFilter drops numbers from the words found by another
+// tokenizer, or if strict is set, any word containing a digit.
+type digitFilter struct {
+	Tokenizer
+	strict bool
+}
+
+func (df *digitFilter) Tokens(text string, spans []span) []span {
+	n := len(spans)
+	spans = df.Tokenizer.Tokens(text, spans)
+	kept := spans[:n]
+	for _, s := range spans[n:] {
+		w := text[s.start:s.end]
+		hasDigit := strings.IndexFunc(w, unicode.IsDigit) >= 0
+		if !hasDigit || (!df.strict &&
+			strings.IndexFunc(w, unicode.IsLetter) >= 0) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Returns the words the tokenizer finds in the text, joined by '|'.
+func tokenize(tk Tokenizer, text string) string {
+	var res []string
+	for _, s := range tk.Tokens(text, nil) {
+		res = append(res, text[s.start:s.end])
+	}
+	return strings.Join(res, "|")
+}
+
+// Test the Unicode tokenizer's handling of apostrophes, hyphens,
+// symbols and scripts other than Latin.
+func TestUnicodeTokenizer(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"The quick brown fox", "The|quick|brown|fox"},
+		{"don't won’t 'quoted' dogs' toys", "don't|won’t|quoted|dogs|toys"},
+		{"e-mail state-of-the-art -dash- a--b", "e-mail|state-of-the-art|dash|a|b"},
+		{"C++ and C# but a+b and x+++", "C++|and|C#|but|a|b|and|x"},
+		{"snake_case mp3 2024", "snake_case|mp3|2024"},
+		{"naïve café Ελληνικά हिन्दी", "naïve|café|Ελληνικά|हिन्दी"},
+		{"  ,;trailing", "trailing"},
+		{"", ""},
+	}
+	tk := &unicodeTokenizer{joinHyphens: true}
+	for _, tc := range tests {
+		if got := tokenize(tk, tc.text); got != tc.expected {
+			t.Errorf("%q: expected %q, got %q\n", tc.text, tc.expected, got)
+		}
+	}
+
+	tk.joinHyphens = false
+	if got := tokenize(tk, "e-mail"); got != "e|mail" {
+		t.Errorf("expected split hyphen, got %q\n", got)
+	}
+}
+
+// Test the digit policies, which apply to any tokenizer.
+func TestDigitPolicy(t *testing.T) {
+	text := "mp3 players from 2024 and 1990s"
+	tests := map[string]string{
+		"any":   "mp3|players|from|2024|and|1990s",
+		"mixed": "mp3|players|from|and|1990s",
+		"none":  "players|from|and",
+	}
+	for _, kind := range []string{"unicode", "regexp"} {
+		for policy, expected := range tests {
+			tk, err := newTokenizer(kind, true, policy)
+			if err != nil {
+				t.Fatalf("newTokenizer(%s, %s) failed: %v\n", kind, policy, err)
+			}
+			if got := tokenize(tk, text); got != expected {
+				t.Errorf("%s %s: expected %q, got %q\n", kind, policy,
+					expected, got)
+			}
+		}
+	}
+	if _, err := newTokenizer("whitespace", true, "any"); err == nil {
+		t.Fatalf("expected error for unknown tokenizer\n")
+	}
+	if _, err := newTokenizer("unicode", true, "some"); err == nil {
+		t.Fatalf("expected error for unknown digits policy\n")
+	}
+}
+
+// The body text of the test server's pages, along with some text in
+// other scripts, repeated to make a page of a realistic size.
+var benchText = strings.Repeat(`
+	The quick brown fox jumped over the lazy dog's parallelogram, er, tarantulas
+	A parallelogram is a really cool shape!  No kidding, a parallelogram.
+	But tarantulas are kind of cool too!  Die schnelle braune Füchsin, η γρήγορη
+	καφέ αλεπού, быстрая коричневая лиса, तेज़ भूरी लोमड़ी, 2024-01-01 e-mail C++
+	`, 50)
+
+func benchmarkTokenizer(b *testing.B, tk Tokenizer) {
+	var spans []span
+	b.SetBytes(int64(len(benchText)))
+	for i := 0; i < b.N; i++ {
+		spans = tk.Tokens(benchText, spans[:0])
+	}
+}
+
+func BenchmarkUnicodeTokenizer(b *testing.B) {
+	benchmarkTokenizer(b, &unicodeTokenizer{joinHyphens: true})
+}
+
+func BenchmarkRegexpTokenizer(b *testing.B) {
+	benchmarkTokenizer(b, &regexpTokenizer{re: words})
+}
@@ -1,28 +1,27 @@
 package main
 
-// Function implementing an unlimited length buffered chan string.
+// Function implementing an unlimited length buffered channel.
 // Caller is provided send and receive channels which shoud be used
-// as any other channel. Go has no generics, so the only way to
-// generalize this is to use chan (interface{}), which makes things
-// inconvenient for the caller.  Might consider rewriting it that way
-// if I end up liking this approach :-)
+// as any other channel.  This started life as a chan string, before
+// Go had generics, but the crawl tasks now carry more than a string,
+// so it is generic over the element type.
 // This excellent blog post was the seed for this
 // https://medium.com/capital-one-developers/building-an-unbounded-channel-in-go-789e175cd2cd
-func unlimitedStringChannel(capacity int) (chan<- string, <-chan string) {
-	snd := make(chan (string))
-	rcv := make(chan (string))
-	data := make([]string, 0, capacity)
+func unlimitedChannel[T any](capacity int) (chan<- T, <-chan T) {
+	snd := make(chan T)
+	rcv := make(chan T)
+	data := make([]T, 0, capacity)
 
-	go func(snd <-chan string, rcv chan<- string) {
-		var r chan<- string
-		var nxt string
+	go func(snd <-chan T, rcv chan<- T) {
+		var r chan<- T
+		var nxt, zero T
 		s := snd
 		for {
 
 			// No data to send, then can't write to channel.
 			if len(data) == 0 {
 				r = nil
-				nxt = ""
+				nxt = zero
 			} else {
 				r = rcv
 				nxt = data[0]
@@ -30,6 +29,7 @@ func unlimitedStringChannel(capacity int) (chan<- string, <-chan string) {
 
 			select {
 			case r <- nxt:
+				data[0] = zero
 				data = data[1:]
 			case d, ok := <-s:
 				if !ok {
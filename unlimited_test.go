@@ -7,7 +7,7 @@ import (
 )
 
 func TestUnlimitedBuffering(t *testing.T) {
-	snd, rdr := unlimitedStringChannel(0)
+	snd, rdr := unlimitedChannel[string](0)
 	lcnt := 100
 	gcnt := 50
 
@@ -0,0 +1,90 @@
+// The URL filters restrict the crawl frontier using patterns given
+// on the command line, so that we can confine a crawl to part of a
+// site, or keep it out of areas such as search results and calendars
+// that generate endless numbers of pages.
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Prefix marking a pattern as a regular expression rather than a glob.
+const regexpPrefix = "re:"
+
+// A urlPattern is either a glob matched against the URL's path and
+// query, in which '*' matches any run of characters (including '/'
+// and '?') and everything else is literal, or a regular expression
+// that may match anywhere in the full URL.
+type urlPattern struct {
+	text string
+	re   *regexp.Regexp
+	glob bool
+}
+
+// A patternList is a repeatable command line flag of URL patterns.
+type patternList []*urlPattern
+
+// String is part of flag.Value.
+func (pl *patternList) String() string {
+	var s []string
+	for _, p := range *pl {
+		s = append(s, p.text)
+	}
+	return strings.Join(s, " ")
+}
+
+// Set is part of flag.Value.
+func (pl *patternList) Set(v string) error {
+	p, err := newURLPattern(v)
+	if err != nil {
+		return err
+	}
+	*pl = append(*pl, p)
+	return nil
+}
+
+// Reports whether any of the patterns match the URL.
+func (pl patternList) matches(u *url.URL) bool {
+	for _, p := range pl {
+		if p.matches(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compiles a pattern in either of its forms.
+func newURLPattern(text string) (*urlPattern, error) {
+	if strings.HasPrefix(text, regexpPrefix) {
+		re, err := regexp.Compile(text[len(regexpPrefix):])
+		if err != nil {
+			return nil, fmt.Errorf("bad URL pattern '%s': %v", text, err)
+		}
+		return &urlPattern{text: text, re: re}, nil
+	}
+
+	parts := strings.Split(text, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	re := regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+	return &urlPattern{text: text, re: re, glob: true}, nil
+}
+
+// Reports whether the pattern matches the URL.
+func (p *urlPattern) matches(u *url.URL) bool {
+	if !p.glob {
+		return p.re.MatchString(u.String())
+	}
+	s := u.EscapedPath()
+	if s == "" {
+		s = "/"
+	}
+	if u.RawQuery != "" {
+		s += "?" + u.RawQuery
+	}
+	return p.re.MatchString(s)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+// Test the glob and regular expression pattern forms.
+func TestURLPatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		url     string
+		match   bool
+	}{
+		{"/docs/*", "http://example.com/docs/intro", true},
+		{"/docs/*", "http://example.com/docs/a/b/c?x=1", true},
+		{"/docs/*", "http://example.com/api/docs/", false},
+		{"/search?*", "http://example.com/search?q=go", true},
+		{"/search?*", "http://example.com/search", false},
+		{"*/tag/*", "http://example.com/blog/tag/go", true},
+		{"/", "http://example.com", true},
+		{"/a.b", "http://example.com/aXb", false},
+		{"re:/calendar/\\d{4}", "http://example.com/calendar/2024/01", true},
+		{"re:(login|signin)", "https://example.com/account/signin?next=/", true},
+		{"re:^https://", "http://example.com/", false},
+	}
+	for _, tc := range tests {
+		p, err := newURLPattern(tc.pattern)
+		if err != nil {
+			t.Fatalf("bad pattern %q: %v\n", tc.pattern, err)
+		}
+		u, _ := url.Parse(tc.url)
+		if got := p.matches(u); got != tc.match {
+			t.Errorf("%q matching %q: expected %t, got %t\n",
+				tc.pattern, tc.url, tc.match, got)
+		}
+	}
+
+	var pl patternList
+	if err := pl.Set("re:("); err == nil {
+		t.Fatalf("expected error for bad regular expression\n")
+	}
+}